@@ -2,9 +2,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/christgf/env"
@@ -21,6 +25,37 @@ var (
   # Run the sidecar with the defaults.
   skpr-metrics-adapter-sidecar
 
+  # Query FPM over a unix socket.
+  export SKPR_FPM_METRICS_ADAPTER_ENDPOINT=unix:///run/php-fpm.sock
+  skpr-metrics-adapter-sidecar
+
+  # Query multiple FPM pools.
+  export SKPR_FPM_METRICS_ADAPTER_ENDPOINT=127.0.0.1:9000,127.0.0.1:9001,unix:///run/php-fpm-cron.sock
+  skpr-metrics-adapter-sidecar
+
+  # Export process manager configuration eg. pm.max_children.
+  export SKPR_FPM_METRICS_ADAPTER_CONFIG=/etc/php/php-fpm.conf
+  skpr-metrics-adapter-sidecar
+
+  # Resolve relative includes against a custom prefix eg. php-fpm --prefix /opt/php
+  export SKPR_FPM_METRICS_ADAPTER_CONFIG=/opt/php/etc/php-fpm.conf
+  export SKPR_FPM_METRICS_ADAPTER_PREFIX=/opt/php
+  skpr-metrics-adapter-sidecar
+
+  # Query a pool with a custom status path which only allows local requests.
+  export SKPR_FPM_METRICS_ADAPTER_STATUS_PATH=/fpm-status
+  export SKPR_FPM_METRICS_ADAPTER_PARAMS=SERVER_NAME=localhost,REMOTE_ADDR=127.0.0.1
+  skpr-metrics-adapter-sidecar
+
+  # Query the plain text status page eg. when JSON is not available.
+  export SKPR_FPM_METRICS_ADAPTER_FORMAT=text
+  skpr-metrics-adapter-sidecar
+
+  # Mark the pod as not ready when requests start queueing.
+  # Probe the sidecar on /healthz (liveness) and /readyz (readiness).
+  export SKPR_FPM_METRICS_ADAPTER_QUEUE_THRESHOLD=10
+  skpr-metrics-adapter-sidecar
+
   # Enable debug logs.
   export SKPR_FPM_METRICS_ADAPTER_LOG_LEVEL=debug
   skpr-metrics-adapter-sidecar`
@@ -29,6 +64,9 @@ var (
 // Options for this sidecar application.
 type Options struct {
 	ServerConfig sidecar.ServerConfig
+	ClientConfig fpm.ClientConfig
+	Format       string
+	Params       []string
 	LogLevel     string
 }
 
@@ -62,9 +100,30 @@ func main() {
 
 			logger.Info("Booting sidecar")
 
-			client := fpm.NewFpmTcpClient(o.ServerConfig.Endpoint, o.ServerConfig.Timeout)
+			format, err := fpm.ParseFormat(o.Format)
+			if err != nil {
+				return err
+			}
+
+			o.ClientConfig.Format = format
+
+			o.ClientConfig.Params, err = parseParams(o.Params)
+			if err != nil {
+				return err
+			}
+
+			var clients []fpm.FcmClient
+
+			for _, endpoint := range o.ServerConfig.Endpoints {
+				client, err := fpm.NewClient(endpoint, o.ClientConfig)
+				if err != nil {
+					return fmt.Errorf("failed to create fpm client: %w", err)
+				}
+
+				clients = append(clients, client)
+			}
 
-			server, err := sidecar.NewServer(logger, o.ServerConfig, client)
+			server, err := sidecar.NewServer(logger, o.ServerConfig, clients)
 			if err != nil {
 				return fmt.Errorf("failed to start server: %w", err)
 			}
@@ -83,11 +142,51 @@ func main() {
 	cmd.PersistentFlags().StringVar(&o.LogLevel, "log-level", env.String("SKPR_FPM_METRICS_ADAPTER_LOG_LEVEL", "info"), "Set the logging level")
 	cmd.PersistentFlags().StringVar(&o.ServerConfig.Port, "port", env.String("SKPR_FPM_METRICS_ADAPTER_PORT", ":80"), "Port which our metrics endpoint will be served on")
 	cmd.PersistentFlags().StringVar(&o.ServerConfig.Path, "path", env.String("SKPR_FPM_METRICS_ADAPTER_PATH", "/metrics"), "Path which our metrics endpoint will be served on")
-	cmd.PersistentFlags().StringVar(&o.ServerConfig.Endpoint, "endpoint", env.String("SKPR_FPM_METRICS_ADAPTER_ENDPOINT", "127.0.0.1:9000"), "Endpoint which we will poll for FPM status information")
-	cmd.PersistentFlags().DurationVar(&o.ServerConfig.Timeout, "timeout", env.Duration("SKPR_FPM_METRICS_ADAPTER_QUERY_STATUS_TIMEOUT", 5*time.Second), "Set the query status timeout")
-
-	err := cmd.Execute()
+	cmd.PersistentFlags().StringSliceVar(&o.ServerConfig.Endpoints, "endpoint", strings.Split(env.String("SKPR_FPM_METRICS_ADAPTER_ENDPOINT", "127.0.0.1:9000"), ","), "Endpoints which we will poll for FPM status information, one per pool (host:port, tcp://host:port or unix:///path/to/socket)")
+	cmd.PersistentFlags().StringVar(&o.ServerConfig.ConfigPath, "config", env.String("SKPR_FPM_METRICS_ADAPTER_CONFIG", ""), "Path to the php-fpm.conf file used to export process manager configuration eg. pm.max_children")
+	cmd.PersistentFlags().StringVar(&o.ServerConfig.ConfigPrefix, "prefix", env.String("SKPR_FPM_METRICS_ADAPTER_PREFIX", fpm.DefaultPrefix), "Prefix which FPM resolves relative include paths against (php-fpm --prefix)")
+	cmd.PersistentFlags().DurationVar(&o.ServerConfig.PollInterval, "poll-interval", env.Duration("SKPR_FPM_METRICS_ADAPTER_POLL_INTERVAL", 5*time.Second), "How often to query FPM in the background (0 to query when metrics are requested)")
+	cmd.PersistentFlags().DurationVar(&o.ServerConfig.FloodControl, "flood-control", env.Duration("SKPR_FPM_METRICS_ADAPTER_FLOOD_CONTROL", sidecar.DefaultFloodControl), "Minimum amount of time between FPM status queries")
+	cmd.PersistentFlags().DurationVar(&o.ServerConfig.MaxAge, "max-age", env.Duration("SKPR_FPM_METRICS_ADAPTER_MAX_AGE", time.Minute), "How long a pool can fail to respond before its status metrics are no longer served (0 to disable)")
+	cmd.PersistentFlags().DurationVar(&o.ServerConfig.ReadTimeout, "read-timeout", env.Duration("SKPR_FPM_METRICS_ADAPTER_READ_TIMEOUT", 5*time.Second), "Maximum duration for reading a request")
+	cmd.PersistentFlags().DurationVar(&o.ServerConfig.WriteTimeout, "write-timeout", env.Duration("SKPR_FPM_METRICS_ADAPTER_WRITE_TIMEOUT", 10*time.Second), "Maximum duration for writing a response")
+	cmd.PersistentFlags().DurationVar(&o.ServerConfig.IdleTimeout, "idle-timeout", env.Duration("SKPR_FPM_METRICS_ADAPTER_IDLE_TIMEOUT", 60*time.Second), "Maximum duration to wait for the next request on a keep-alive connection")
+	cmd.PersistentFlags().DurationVar(&o.ServerConfig.DrainTimeout, "drain-timeout", env.Duration("SKPR_FPM_METRICS_ADAPTER_DRAIN_TIMEOUT", 10*time.Second), "Maximum duration to wait for in-flight requests during shutdown")
+	cmd.PersistentFlags().Int64Var(&o.ServerConfig.QueueThreshold, "queue-threshold", env.Int64("SKPR_FPM_METRICS_ADAPTER_QUEUE_THRESHOLD", 0), "Number of items in the listen queue at which the readiness endpoint returns a 503 (0 to disable)")
+	cmd.PersistentFlags().DurationVar(&o.ClientConfig.Timeout, "timeout", env.Duration("SKPR_FPM_METRICS_ADAPTER_QUERY_STATUS_TIMEOUT", 5*time.Second), "Set the query status timeout")
+	cmd.PersistentFlags().StringVar(&o.Format, "format", env.String("SKPR_FPM_METRICS_ADAPTER_FORMAT", string(fpm.FormatJSON)), "Format used to query the FPM status page (json, text, xml or openmetrics)")
+	cmd.PersistentFlags().StringVar(&o.ClientConfig.StatusPath, "status-path", env.String("SKPR_FPM_METRICS_ADAPTER_STATUS_PATH", fpm.DefaultStatusPath), "Path which FPM responds to with the status page (pm.status_path)")
+	cmd.PersistentFlags().StringSliceVar(&o.Params, "param", strings.Split(env.String("SKPR_FPM_METRICS_ADAPTER_PARAMS", ""), ","), "Extra FastCGI params passed through to FPM with each request (KEY=VALUE)")
+	cmd.PersistentFlags().StringVar(&o.ClientConfig.PingPath, "ping-path", env.String("SKPR_FPM_METRICS_ADAPTER_PING_PATH", fpm.DefaultPingPath), "Path which FPM responds to pings on (ping.path)")
+	cmd.PersistentFlags().StringVar(&o.ClientConfig.PingResponse, "ping-response", env.String("SKPR_FPM_METRICS_ADAPTER_PING_RESPONSE", fpm.DefaultPingResponse), "Response which FPM responds to pings with (ping.response)")
+	cmd.PersistentFlags().BoolVar(&o.ClientConfig.DisableKeepAlive, "disable-keep-alive", env.Bool("SKPR_FPM_METRICS_ADAPTER_DISABLE_KEEP_ALIVE", false), "Open a new connection to FPM for each request, rather than keeping one open which occupies an FPM worker")
+
+	// Cancelled on SIGTERM (pod termination) or SIGINT so the server can shutdown gracefully.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	err := cmd.ExecuteContext(ctx)
 	if err != nil {
 		panic(err)
 	}
 }
+
+// Helper function to parse KEY=VALUE pairs into FastCGI params.
+func parseParams(pairs []string) (map[string]string, error) {
+	params := make(map[string]string)
+
+	for _, pair := range pairs {
+		if pair == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid param, expected KEY=VALUE: %q", pair)
+		}
+
+		params[key] = value
+	}
+
+	return params, nil
+}
@@ -5,14 +5,15 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/christgf/env"
 	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/component-base/metrics/legacyregistry"
 	"sigs.k8s.io/custom-metrics-apiserver/pkg/apiserver/metrics"
 	basecmd "sigs.k8s.io/custom-metrics-apiserver/pkg/cmd"
-	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider"
 
 	customprovider "github.com/skpr/fpm-metrics-adapter/internal/provider"
 )
@@ -27,6 +28,19 @@ var (
   # Run the adapter with the defaults.
   skpr-fpm-metrics-adapter
 
+  # Only watch pods for a single application.
+  export SKPR_FPM_METRICS_ADAPTER_POD_NAMESPACE=drupal
+  export SKPR_FPM_METRICS_ADAPTER_POD_SELECTOR=app=drupal
+  skpr-fpm-metrics-adapter
+
+  # Expose the metrics declared in a rules file, which is reloaded when it changes.
+  export SKPR_FPM_METRICS_ADAPTER_RULES=/etc/skpr-fpm-metrics-adapter/rules.yaml
+  skpr-fpm-metrics-adapter
+
+  # Advertise the PHP worker metrics exported by pods annotated with fpm.skpr.io/discover=true
+  export SKPR_FPM_METRICS_ADAPTER_DISCOVERY_ALLOW=php_worker_.*
+  skpr-fpm-metrics-adapter
+
   # Run the adapter with a longer cache expiration.
   export SKPR_FPM_METRICS_ADAPTER_CACHE_EXPIRATION=120s
   skpr-fpm-metrics-adapter`
@@ -38,12 +52,7 @@ type Adapter struct {
 }
 
 // Helper function to instantiate the custom metrics provider.
-func (a *Adapter) getProvider(logger *slog.Logger, cacheExpiration time.Duration) (provider.CustomMetricsProvider, error) {
-	config, err := a.ClientConfig()
-	if err != nil {
-		return nil, fmt.Errorf("unable to construct client config: %w", err)
-	}
-
+func (a *Adapter) getProvider(logger *slog.Logger, pods *customprovider.PodCache, rules *customprovider.Rules, config customprovider.Config) (*customprovider.Provider, error) {
 	client, err := a.DynamicClient()
 	if err != nil {
 		return nil, fmt.Errorf("unable to construct dynamic client: %w", err)
@@ -54,13 +63,30 @@ func (a *Adapter) getProvider(logger *slog.Logger, cacheExpiration time.Duration
 		return nil, fmt.Errorf("unable to construct discovery REST mapper: %w", err)
 	}
 
-	return customprovider.New(logger, client, config, mapper, cacheExpiration), nil
+	return customprovider.New(logger, client, pods, rules, mapper, config), nil
+}
+
+// Helper function to instantiate the shared pod cache.
+func (a *Adapter) getPodCache(config customprovider.PodCacheConfig) (*customprovider.PodCache, error) {
+	clientConfig, err := a.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("unable to construct client config: %w", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to construct clientset: %w", err)
+	}
+
+	return customprovider.NewPodCache(clientset, config)
 }
 
 // Options for this sidecar application.
 type Options struct {
-	CacheExpiration time.Duration
-	LogLevel        string
+	Provider customprovider.Config
+	PodCache customprovider.PodCacheConfig
+	Rules    customprovider.RulesConfig
+	LogLevel string
 }
 
 func main() {
@@ -96,21 +122,50 @@ func main() {
 			adapter := &Adapter{}
 			adapter.Name = adapterName
 
+			// Metrics must be registered before they are recorded, otherwise they are lost.
+			logger.Info("Registering metrics")
+
+			if err := metrics.RegisterMetrics(legacyregistry.Register); err != nil {
+				return fmt.Errorf("failed to register metrics: %w", err)
+			}
+
+			if err := customprovider.RegisterMetrics(legacyregistry.Register); err != nil {
+				return fmt.Errorf("failed to register provider metrics: %w", err)
+			}
+
+			logger.Info("Starting pod cache")
+
+			pods, err := adapter.getPodCache(o.PodCache)
+			if err != nil {
+				return fmt.Errorf("failed to get pod cache: %w", err)
+			}
+
+			if err := pods.Start(cmd.Context()); err != nil {
+				return fmt.Errorf("failed to start pod cache: %w", err)
+			}
+
+			logger.Info("Loading rules")
+
+			rules, err := customprovider.NewRules(logger, o.Rules)
+			if err != nil {
+				return fmt.Errorf("failed to load rules: %w", err)
+			}
+
+			rules.Start(cmd.Context())
+
 			logger.Info("Getting provider")
 
-			provider, err := adapter.getProvider(logger, o.CacheExpiration)
+			provider, err := adapter.getProvider(logger, pods, rules, o.Provider)
 			if err != nil {
 				return fmt.Errorf("failed to get provider: %w", err)
 			}
 
-			logger.Info("Registering metrics")
+			if err := provider.Start(cmd.Context()); err != nil {
+				return fmt.Errorf("failed to start metric discovery: %w", err)
+			}
 
 			adapter.WithCustomMetrics(provider)
 
-			if err := metrics.RegisterMetrics(legacyregistry.Register); err != nil {
-				return fmt.Errorf("failed to register metrics: %w", err)
-			}
-
 			logger.Info("Running adapter")
 
 			if err := adapter.Run(cmd.Context()); err != nil {
@@ -124,7 +179,21 @@ func main() {
 	}
 
 	cmd.PersistentFlags().StringVar(&o.LogLevel, "log-level", env.String("SKPR_FPM_METRICS_ADAPTER_LOG_LEVEL", "info"), "Set the logging level")
-	cmd.PersistentFlags().DurationVar(&o.CacheExpiration, "cache-expiration", env.Duration("SKPR_FPM_METRICS_ADAPTER_CACHE_EXPIRATION", 10*time.Second), "How long to keep cached metrics")
+	cmd.PersistentFlags().DurationVar(&o.Provider.CacheExpiration, "cache-expiration", env.Duration("SKPR_FPM_METRICS_ADAPTER_CACHE_EXPIRATION", 10*time.Second), "How long to keep the metrics scraped from each pod")
+	cmd.PersistentFlags().StringVar(&o.PodCache.Namespace, "pod-namespace", env.String("SKPR_FPM_METRICS_ADAPTER_POD_NAMESPACE", ""), "Only watch pods in this namespace (all namespaces when empty)")
+	cmd.PersistentFlags().StringVar(&o.PodCache.LabelSelector, "pod-selector", env.String("SKPR_FPM_METRICS_ADAPTER_POD_SELECTOR", ""), "Only watch pods which match this label selector eg. app=drupal")
+	cmd.PersistentFlags().DurationVar(&o.PodCache.Resync, "pod-resync", env.Duration("SKPR_FPM_METRICS_ADAPTER_POD_RESYNC", 10*time.Minute), "How often the pod cache is resynced (0 to disable)")
+	cmd.PersistentFlags().StringVar(&o.Rules.Path, "rules", env.String("SKPR_FPM_METRICS_ADAPTER_RULES", ""), "Path to a YAML file which declares the metrics to expose (all sidecar metrics are exposed when empty)")
+	cmd.PersistentFlags().DurationVar(&o.Rules.ReloadInterval, "rules-reload-interval", env.Duration("SKPR_FPM_METRICS_ADAPTER_RULES_RELOAD_INTERVAL", 30*time.Second), "How often the rules file is checked for changes (0 to disable)")
+	cmd.PersistentFlags().DurationVar(&o.Provider.Discovery.Interval, "discovery-interval", env.Duration("SKPR_FPM_METRICS_ADAPTER_DISCOVERY_INTERVAL", time.Minute), "How often metrics are discovered from pods annotated with "+customprovider.AnnotationDiscovery+"=true (0 to disable)")
+	cmd.PersistentFlags().StringSliceVar(&o.Provider.Discovery.Allow, "discovery-allow", strings.Split(env.String("SKPR_FPM_METRICS_ADAPTER_DISCOVERY_ALLOW", ""), ","), "Only advertise discovered metrics which match one of these regular expressions")
+	cmd.PersistentFlags().StringSliceVar(&o.Provider.Discovery.Deny, "discovery-deny", strings.Split(env.String("SKPR_FPM_METRICS_ADAPTER_DISCOVERY_DENY", ""), ","), "Never advertise discovered metrics which match one of these regular expressions")
+	cmd.PersistentFlags().Float64Var(&o.Provider.Quantile, "quantile", env.Float64("SKPR_FPM_METRICS_ADAPTER_QUANTILE", customprovider.DefaultQuantile), "Quantile returned for histogram and summary metrics, unless a rule declares its own")
+	cmd.PersistentFlags().DurationVar(&o.Provider.RateWindow, "rate-window", env.Duration("SKPR_FPM_METRICS_ADAPTER_RATE_WINDOW", customprovider.DefaultRateWindow), "How long a scrape is kept for computing the per-second rate of counters on the next scrape")
+	cmd.PersistentFlags().DurationVar(&o.Provider.MaxAge, "max-age", env.Duration("SKPR_FPM_METRICS_ADAPTER_MAX_AGE", time.Minute), "Refuse metrics from pods which have not successfully queried FPM within this duration (0 to disable)")
+	cmd.PersistentFlags().IntVar(&o.Provider.Concurrency, "scrape-concurrency", env.Int("SKPR_FPM_METRICS_ADAPTER_SCRAPE_CONCURRENCY", customprovider.DefaultConcurrency), "Maximum number of pods scraped at once when getting metrics by selector")
+	cmd.PersistentFlags().DurationVar(&o.Provider.PodTimeout, "scrape-timeout", env.Duration("SKPR_FPM_METRICS_ADAPTER_SCRAPE_TIMEOUT", customprovider.DefaultPodTimeout), "Maximum duration for scraping a single pod (0 to use the default)")
+	cmd.PersistentFlags().DurationVar(&o.Provider.SelectorTimeout, "selector-timeout", env.Duration("SKPR_FPM_METRICS_ADAPTER_SELECTOR_TIMEOUT", 15*time.Second), "Maximum duration for scraping all pods which match a selector, partial results are returned after this (0 to disable)")
 
 	err := cmd.Execute()
 	if err != nil {
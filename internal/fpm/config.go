@@ -0,0 +1,244 @@
+package fpm
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SectionGlobal is the php-fpm.conf section which does not belong to a pool.
+	SectionGlobal = "global"
+
+	// ProcessManagerStatic is the process manager type which always runs pm.max_children processes.
+	ProcessManagerStatic = "static"
+
+	// DefaultPrefix which FPM resolves relative include paths against, unless started with --prefix.
+	DefaultPrefix = "/usr/local"
+)
+
+// Matches environment variables which FPM expands in config values eg. ${PHP_FPM_MAX_CHILDREN}
+var envPattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// Config loaded from the php-fpm.conf and pool.d files.
+type Config struct {
+	// Pools keyed by name.
+	Pools map[string]PoolConfig
+}
+
+// PoolConfig for the process manager of a single pool.
+type PoolConfig struct {
+	// The name of the pool eg. www
+	Name string
+	// The process manager type - static, dynamic or ondemand.
+	ProcessManager string
+	// The maximum number of child processes (pm.max_children).
+	MaxChildren int64
+	// The number of child processes created on startup (pm.start_servers).
+	StartServers int64
+	// The desired minimum number of idle server processes (pm.min_spare_servers).
+	MinSpareServers int64
+	// The desired maximum number of idle server processes (pm.max_spare_servers).
+	MaxSpareServers int64
+	// The number of requests each child process should execute before respawning (pm.max_requests).
+	MaxRequests int64
+}
+
+// ProcessUtilization is the ratio of active processes to pm.max_children.
+// Static pools always run pm.max_children processes, so the total number of processes is used when it is not configured.
+// Returns false for dynamic and ondemand pools without pm.max_children, where the total number of processes is not the limit.
+func (c PoolConfig) ProcessUtilization(status Status) (float64, bool) {
+	if c.MaxChildren > 0 {
+		return ratio(status.ActiveProcesses, c.MaxChildren), true
+	}
+
+	if status.ProcessManager == ProcessManagerStatic {
+		return status.ProcessUtilization(), true
+	}
+
+	return 0, false
+}
+
+// ParseConfig loads the FPM configuration file, following any include directives.
+// Relative include paths are resolved against the prefix, like FPM does.
+func ParseConfig(path, prefix string) (Config, error) {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+
+	config := Config{
+		Pools: make(map[string]PoolConfig),
+	}
+
+	section := SectionGlobal
+
+	if err := parseConfigFile(path, prefix, &config, &section, map[string]bool{}); err != nil {
+		return config, err
+	}
+
+	if len(config.Pools) == 0 {
+		return config, fmt.Errorf("no pools were declared by %s (prefix %s)", path, prefix)
+	}
+
+	return config, nil
+}
+
+// Helper function to parse a single ini file into the config.
+// The current section is shared so that included files can continue a pool section, and open one which continues after the include.
+func parseConfigFile(path, prefix string, config *Config, section *string, visited map[string]bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	// Avoid include loops.
+	if visited[abs] {
+		return nil
+	}
+
+	visited[abs] = true
+
+	file, err := os.Open(abs)
+	if err != nil {
+		return fmt.Errorf("failed to open config: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			*section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: invalid line: %q", abs, number, line)
+		}
+
+		key = strings.TrimSpace(key)
+		value = parseValue(strings.TrimSpace(value))
+
+		if key == "include" {
+			if err := parseConfigInclude(prefix, value, config, section, visited); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if *section == SectionGlobal {
+			continue
+		}
+
+		if err := setPoolConfig(config, *section, key, value); err != nil {
+			return fmt.Errorf("%s:%d: %w", abs, number, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Helper function to parse the files matching an include glob, relative to the prefix.
+func parseConfigInclude(prefix, pattern string, config *Config, section *string, visited map[string]bool) error {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(prefix, pattern)
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid include: %w", err)
+	}
+
+	// Like FPM, the last section opened by an included file remains active in the including file.
+	for _, match := range matches {
+		if err := parseConfigFile(match, prefix, config, section, visited); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Helper function to set a process manager directive for a pool.
+func setPoolConfig(config *Config, name, key, value string) error {
+	pool := config.Pools[name]
+	pool.Name = name
+
+	var err error
+
+	switch key {
+	case "pm":
+		pool.ProcessManager = value
+	case "pm.max_children":
+		pool.MaxChildren, err = strconv.ParseInt(value, 10, 64)
+	case "pm.start_servers":
+		pool.StartServers, err = strconv.ParseInt(value, 10, 64)
+	case "pm.min_spare_servers":
+		pool.MinSpareServers, err = strconv.ParseInt(value, 10, 64)
+	case "pm.max_spare_servers":
+		pool.MaxSpareServers, err = strconv.ParseInt(value, 10, 64)
+	case "pm.max_requests":
+		pool.MaxRequests, err = strconv.ParseInt(value, 10, 64)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+
+	config.Pools[name] = pool
+
+	return nil
+}
+
+// Helper function to get a value without its comment and quotes.
+// Environment variables are expanded, except in single quoted values which FPM treats as raw strings.
+func parseValue(value string) string {
+	value = stripComment(value)
+
+	if strings.HasPrefix(value, "'") {
+		return unquote(value)
+	}
+
+	return unquote(expandEnv(value))
+}
+
+// Helper function to expand ${VAR} environment variables. Unset variables are expanded to an empty string.
+func expandEnv(value string) string {
+	return envPattern.ReplaceAllStringFunc(value, func(match string) string {
+		return os.Getenv(envPattern.FindStringSubmatch(match)[1])
+	})
+}
+
+// Helper function to remove a trailing comment from an unquoted value.
+func stripComment(value string) string {
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, `'`) {
+		return value
+	}
+
+	if i := strings.Index(value, ";"); i != -1 {
+		return strings.TrimSpace(value[:i])
+	}
+
+	return value
+}
+
+// Helper function to remove quotes surrounding a value.
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+
+	return value
+}
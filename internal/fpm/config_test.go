@@ -0,0 +1,124 @@
+package fpm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig("testdata/config/php-fpm.conf", "testdata/config")
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]PoolConfig{
+		"www": {
+			Name:            "www",
+			ProcessManager:  "dynamic",
+			MaxChildren:     20,
+			StartServers:    4,
+			MinSpareServers: 2,
+			MaxSpareServers: 6,
+			MaxRequests:     1000,
+		},
+		"cron": {
+			Name:           "cron",
+			ProcessManager: "static",
+			MaxChildren:    2,
+		},
+	}, config.Pools)
+}
+
+func TestParseConfigIncludeSection(t *testing.T) {
+	config, err := ParseConfig("testdata/config-section/php-fpm.conf", "testdata/config-section")
+	assert.NoError(t, err)
+
+	// Directives following the include apply to the pool declared by the included file.
+	assert.Equal(t, map[string]PoolConfig{
+		"www": {
+			Name:           "www",
+			ProcessManager: "static",
+			MaxChildren:    8,
+			MaxRequests:    250,
+		},
+	}, config.Pools)
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	_, err := ParseConfig("testdata/config/missing.conf", "testdata/config")
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "php-fpm.conf")
+
+	err = os.WriteFile(path, []byte("[www]\npm.max_children = lots\n"), 0o600)
+	assert.NoError(t, err)
+
+	_, err = ParseConfig(path, "")
+	assert.ErrorContains(t, err, "invalid value for pm.max_children")
+
+	// Unset environment variables are expanded to an empty value.
+	err = os.WriteFile(path, []byte("[www]\npm.max_children = ${FPM_TEST_UNSET_MAX_CHILDREN}\n"), 0o600)
+	assert.NoError(t, err)
+
+	_, err = ParseConfig(path, "")
+	assert.ErrorContains(t, err, "invalid value for pm.max_children")
+
+	// Includes are resolved against the prefix, not the including file.
+	_, err = ParseConfig("testdata/config/php-fpm.conf", t.TempDir())
+	assert.ErrorContains(t, err, "no pools")
+}
+
+func TestParseConfigEnv(t *testing.T) {
+	t.Setenv("FPM_TEST_PM", "ondemand")
+	t.Setenv("FPM_TEST_MAX_CHILDREN", "30")
+
+	path := filepath.Join(t.TempDir(), "php-fpm.conf")
+
+	err := os.WriteFile(path, []byte(`[www]
+pm = "${FPM_TEST_PM}"
+pm.max_children = ${FPM_TEST_MAX_CHILDREN} ; from the environment
+pm.max_requests = '${FPM_TEST_MAX_CHILDREN}'
+`), 0o600)
+	assert.NoError(t, err)
+
+	_, err = ParseConfig(path, "")
+	// Single quoted values are not expanded.
+	assert.ErrorContains(t, err, "invalid value for pm.max_requests")
+
+	err = os.WriteFile(path, []byte(`[www]
+pm = "${FPM_TEST_PM}"
+pm.max_children = ${FPM_TEST_MAX_CHILDREN} ; from the environment
+`), 0o600)
+	assert.NoError(t, err)
+
+	config, err := ParseConfig(path, "")
+	assert.NoError(t, err)
+
+	assert.Equal(t, PoolConfig{
+		Name:           "www",
+		ProcessManager: "ondemand",
+		MaxChildren:    30,
+	}, config.Pools["www"])
+}
+
+func TestPoolConfigProcessUtilization(t *testing.T) {
+	status := Status{
+		ActiveProcesses: 5,
+		TotalProcesses:  10,
+	}
+
+	utilization, ok := PoolConfig{MaxChildren: 20}.ProcessUtilization(status)
+	assert.True(t, ok)
+	assert.Equal(t, 0.25, utilization)
+
+	// Dynamic and ondemand pools scale below pm.max_children, so the total number of processes is not the limit.
+	_, ok = PoolConfig{}.ProcessUtilization(status)
+	assert.False(t, ok)
+
+	status.ProcessManager = ProcessManagerStatic
+
+	utilization, ok = PoolConfig{}.ProcessUtilization(status)
+	assert.True(t, ok)
+	assert.Equal(t, 0.5, utilization)
+}
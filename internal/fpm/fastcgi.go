@@ -0,0 +1,342 @@
+package fpm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FastCGI record types, roles and flags.
+// https://fastcgi-archives.github.io/FastCGI_Specification.html
+const (
+	fcgiVersion = 1
+
+	fcgiTypeBeginRequest = 1
+	fcgiTypeEndRequest   = 3
+	fcgiTypeParams       = 4
+	fcgiTypeStdin        = 5
+	fcgiTypeStdout       = 6
+	fcgiTypeStderr       = 7
+
+	fcgiRoleResponder = 1
+	fcgiFlagKeepConn  = 1
+
+	fcgiRequestComplete = 0
+
+	// Only one request is in flight per connection, so the request ID is fixed.
+	fcgiRequestID = 1
+
+	fcgiHeaderLen  = 8
+	fcgiMaxContent = 65535
+)
+
+// fcgiHeader which precedes every FastCGI record.
+type fcgiHeader struct {
+	Version       uint8
+	Type          uint8
+	ID            uint16
+	ContentLength uint16
+	PaddingLength uint8
+	Reserved      uint8
+}
+
+// FastCGIResponse returned by a FastCGI responder.
+type FastCGIResponse struct {
+	// StatusCode from the Status header, defaults to 200 when not provided.
+	StatusCode int
+	// Header provided by the responder.
+	Header http.Header
+	// Body which followed the headers on stdout.
+	Body []byte
+	// Stderr written by the responder eg. "Primary script unknown".
+	Stderr []byte
+	// AppStatus is the exit status reported by the responder.
+	AppStatus uint32
+}
+
+// FastCGIClient for sending requests to a FastCGI responder eg. FPM.
+// When keep-alive is enabled the connection is reused between requests. FPM dedicates a worker to each connection
+// until it is closed, so a kept alive connection pins one worker of the pool between requests.
+type FastCGIClient struct {
+	network   string
+	address   string
+	keepAlive bool
+	// Ensures only one request uses the connection at a time, while letting waiting requests give up.
+	sem  chan struct{}
+	conn net.Conn
+}
+
+// NewFastCGIClient for the given network (tcp or unix) and address.
+func NewFastCGIClient(network, address string, keepAlive bool) *FastCGIClient {
+	return &FastCGIClient{
+		network:   network,
+		address:   address,
+		keepAlive: keepAlive,
+		sem:       make(chan struct{}, 1),
+	}
+}
+
+// Do a request with the given params, returning the full response.
+func (c *FastCGIClient) Do(ctx context.Context, params map[string]string) (*FastCGIResponse, error) {
+	select {
+	case c.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-c.sem }()
+
+	reused := c.conn != nil
+
+	resp, err := c.do(ctx, params)
+	if err != nil && reused && ctx.Err() == nil {
+		// The responder may have closed an idle connection, so try again with a fresh one.
+		resp, err = c.do(ctx, params)
+	}
+
+	return resp, err
+}
+
+// Close the connection, if one is open.
+func (c *FastCGIClient) Close() error {
+	c.sem <- struct{}{}
+	defer func() { <-c.sem }()
+
+	return c.closeConn()
+}
+
+// Helper function to perform a single request attempt.
+// The connection is discarded if anything goes wrong so the next request starts clean.
+func (c *FastCGIClient) do(ctx context.Context, params map[string]string) (*FastCGIResponse, error) {
+	if c.conn == nil {
+		var dialer net.Dialer
+
+		conn, err := dialer.DialContext(ctx, c.network, c.address)
+		if err != nil {
+			return nil, err
+		}
+
+		c.conn = conn
+	}
+
+	conn := c.conn
+
+	// Clear any deadline left over from a previous request on a reused connection.
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = c.closeConn()
+		return nil, err
+	}
+
+	// Interrupt pending reads and writes when the context is done, rather than setting the context deadline on the
+	// connection, so the context error is always set by the time the read or write fails.
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	resp, err := roundTrip(conn, params, c.keepAlive)
+	if err != nil {
+		_ = c.closeConn()
+
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, errors.Join(ctxErr, err)
+		}
+
+		return nil, err
+	}
+
+	// The responder closes the connection once the request has ended, unless asked to keep it.
+	if !c.keepAlive {
+		_ = c.closeConn()
+	}
+
+	return resp, nil
+}
+
+// Helper function to close and forget the connection.
+func (c *FastCGIClient) closeConn() error {
+	if c.conn == nil {
+		return nil
+	}
+
+	err := c.conn.Close()
+	c.conn = nil
+
+	return err
+}
+
+// Helper function to write a request and read the response on a connection.
+func roundTrip(conn io.ReadWriter, params map[string]string, keepAlive bool) (*FastCGIResponse, error) {
+	var (
+		buf   bytes.Buffer
+		flags uint8
+	)
+
+	if keepAlive {
+		flags |= fcgiFlagKeepConn
+	}
+
+	// BEGIN_REQUEST body: role (2 bytes), flags (1 byte), reserved (5 bytes).
+	begin := []byte{0, fcgiRoleResponder, flags, 0, 0, 0, 0, 0}
+	writeRecord(&buf, fcgiTypeBeginRequest, begin)
+	writeStream(&buf, fcgiTypeParams, encodePairs(params))
+	writeStream(&buf, fcgiTypeStdin, nil)
+
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to write request: %w", err)
+	}
+
+	return readResponse(conn)
+}
+
+// Helper function to write a stream as a series of records, terminated by an empty record.
+func writeStream(w *bytes.Buffer, recType uint8, content []byte) {
+	for len(content) > 0 {
+		n := min(len(content), fcgiMaxContent)
+		writeRecord(w, recType, content[:n])
+		content = content[n:]
+	}
+
+	writeRecord(w, recType, nil)
+}
+
+// Helper function to write a single record, padded to a multiple of 8 bytes.
+func writeRecord(w *bytes.Buffer, recType uint8, content []byte) {
+	padding := uint8(-len(content) & 7)
+
+	_ = binary.Write(w, binary.BigEndian, fcgiHeader{
+		Version:       fcgiVersion,
+		Type:          recType,
+		ID:            fcgiRequestID,
+		ContentLength: uint16(len(content)),
+		PaddingLength: padding,
+	})
+
+	w.Write(content)
+	w.Write(make([]byte, padding))
+}
+
+// Helper function to read records until the request has ended.
+func readResponse(r io.Reader) (*FastCGIResponse, error) {
+	var (
+		reader = bufio.NewReader(r)
+		stdout bytes.Buffer
+		stderr bytes.Buffer
+	)
+
+	for {
+		var header fcgiHeader
+
+		if err := binary.Read(reader, binary.BigEndian, &header); err != nil {
+			return nil, fmt.Errorf("failed to read record header: %w", err)
+		}
+
+		if header.Version != fcgiVersion {
+			return nil, fmt.Errorf("unsupported fastcgi version: %d", header.Version)
+		}
+
+		content := make([]byte, int(header.ContentLength)+int(header.PaddingLength))
+
+		if _, err := io.ReadFull(reader, content); err != nil {
+			return nil, fmt.Errorf("failed to read record content: %w", err)
+		}
+
+		content = content[:header.ContentLength]
+
+		if header.ID != fcgiRequestID {
+			// Management records or other requests are not ours to handle.
+			continue
+		}
+
+		switch header.Type {
+		case fcgiTypeStdout:
+			stdout.Write(content)
+		case fcgiTypeStderr:
+			stderr.Write(content)
+		case fcgiTypeEndRequest:
+			// END_REQUEST body: app status (4 bytes), protocol status (1 byte), reserved (3 bytes).
+			if len(content) < 5 {
+				return nil, errors.New("malformed end request record")
+			}
+
+			if protocolStatus := content[4]; protocolStatus != fcgiRequestComplete {
+				return nil, fmt.Errorf("request was not completed, protocol status: %d", protocolStatus)
+			}
+
+			resp, err := parseResponse(stdout.Bytes())
+			if err != nil {
+				return nil, err
+			}
+
+			resp.Stderr = stderr.Bytes()
+			resp.AppStatus = binary.BigEndian.Uint32(content[:4])
+
+			return resp, nil
+		}
+	}
+}
+
+// Helper function to parse the CGI headers and body written to stdout.
+func parseResponse(stdout []byte) (*FastCGIResponse, error) {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(stdout)))
+
+	header, err := reader.ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read headers: %w", err)
+	}
+
+	resp := &FastCGIResponse{
+		StatusCode: http.StatusOK,
+		Header:     http.Header(header),
+	}
+
+	if status := resp.Header.Get("Status"); status != "" {
+		code, _, _ := strings.Cut(status, " ")
+
+		resp.StatusCode, err = strconv.Atoi(code)
+		if err != nil {
+			return nil, fmt.Errorf("malformed status header: %q", status)
+		}
+	}
+
+	resp.Body, err = io.ReadAll(reader.R)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+
+	return resp, nil
+}
+
+// Helper function to encode name-value pairs for a PARAMS stream.
+func encodePairs(pairs map[string]string) []byte {
+	var buf bytes.Buffer
+
+	for name, value := range pairs {
+		writeSize(&buf, len(name))
+		writeSize(&buf, len(value))
+		buf.WriteString(name)
+		buf.WriteString(value)
+	}
+
+	return buf.Bytes()
+}
+
+// Helper function to write a name-value pair length.
+// Lengths under 128 use a single byte, otherwise 4 bytes with the high bit set.
+func writeSize(buf *bytes.Buffer, size int) {
+	if size < 128 {
+		buf.WriteByte(byte(size))
+		return
+	}
+
+	_ = binary.Write(buf, binary.BigEndian, uint32(size)|1<<31)
+}
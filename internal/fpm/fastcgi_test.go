@@ -0,0 +1,440 @@
+package fpm
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingListener records how many connections have been accepted.
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int64
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+
+	return conn, err
+}
+
+// fakeResponse written by the raw FastCGI responder.
+type fakeResponse struct {
+	stdout    string
+	stderr    string
+	appStatus uint32
+	keepConn  bool
+}
+
+// serveRawFastCGI starts a minimal FastCGI responder which writes the given response for every request.
+func serveRawFastCGI(t *testing.T, response fakeResponse) *countingListener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener := &countingListener{Listener: l}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				for {
+					if _, err := readRequest(conn); err != nil {
+						return
+					}
+
+					var buf bytes.Buffer
+
+					writeStream(&buf, fcgiTypeStdout, []byte(response.stdout))
+
+					if response.stderr != "" {
+						writeStream(&buf, fcgiTypeStderr, []byte(response.stderr))
+					}
+
+					end := make([]byte, 8)
+					binary.BigEndian.PutUint32(end, response.appStatus)
+					writeRecord(&buf, fcgiTypeEndRequest, end)
+
+					if _, err := conn.Write(buf.Bytes()); err != nil {
+						return
+					}
+
+					if !response.keepConn {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener
+}
+
+// readRequest reads records until the stdin stream has ended, returning the params.
+func readRequest(r io.Reader) (map[string]string, error) {
+	var params bytes.Buffer
+
+	for {
+		var header fcgiHeader
+
+		if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+			return nil, err
+		}
+
+		content := make([]byte, int(header.ContentLength)+int(header.PaddingLength))
+
+		if _, err := io.ReadFull(r, content); err != nil {
+			return nil, err
+		}
+
+		content = content[:header.ContentLength]
+
+		switch header.Type {
+		case fcgiTypeParams:
+			params.Write(content)
+		case fcgiTypeStdin:
+			if len(content) == 0 {
+				return decodePairs(params.Bytes()), nil
+			}
+		}
+	}
+}
+
+// decodePairs is the inverse of encodePairs.
+func decodePairs(data []byte) map[string]string {
+	pairs := make(map[string]string)
+
+	readSize := func() (int, bool) {
+		if len(data) == 0 {
+			return 0, false
+		}
+
+		if data[0]>>7 == 0 {
+			size := int(data[0])
+			data = data[1:]
+
+			return size, true
+		}
+
+		if len(data) < 4 {
+			return 0, false
+		}
+
+		size := int(binary.BigEndian.Uint32(data) &^ (1 << 31))
+		data = data[4:]
+
+		return size, true
+	}
+
+	for len(data) > 0 {
+		nameLen, ok := readSize()
+		if !ok {
+			break
+		}
+
+		valueLen, ok := readSize()
+		if !ok || len(data) < nameLen+valueLen {
+			break
+		}
+
+		pairs[string(data[:nameLen])] = string(data[nameLen : nameLen+valueLen])
+		data = data[nameLen+valueLen:]
+	}
+
+	return pairs
+}
+
+func TestFastCGIClientKeepAlive(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener := &countingListener{Listener: l}
+	serveFakeFpm(t, listener, http.StatusOK, statusResponse)
+
+	client := NewFastCGIClient("tcp", listener.Addr().String(), true)
+	defer client.Close()
+
+	env := map[string]string{
+		"REQUEST_METHOD":  "GET",
+		"SERVER_PROTOCOL": "HTTP/1.1",
+	}
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Do(context.Background(), env)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		assert.Equal(t, statusResponse, string(resp.Body))
+	}
+
+	// The connection is reused between requests.
+	assert.Equal(t, int64(1), listener.accepted.Load())
+}
+
+func TestFastCGIClientDisableKeepAlive(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener := &countingListener{Listener: l}
+	serveFakeFpm(t, listener, http.StatusOK, statusResponse)
+
+	client := NewFastCGIClient("tcp", listener.Addr().String(), false)
+	defer client.Close()
+
+	env := map[string]string{
+		"REQUEST_METHOD":  "GET",
+		"SERVER_PROTOCOL": "HTTP/1.1",
+	}
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Do(context.Background(), env)
+		assert.NoError(t, err)
+		assert.Equal(t, statusResponse, string(resp.Body))
+	}
+
+	// A new connection is opened for each request, so no worker is held between requests.
+	assert.Equal(t, int64(3), listener.accepted.Load())
+	assert.Nil(t, client.conn)
+}
+
+func TestFastCGIClientWaitContext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	// Accept connections but never respond, like a hung FPM.
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			t.Cleanup(func() {
+				_ = conn.Close()
+			})
+		}
+	}()
+
+	client := NewFastCGIClient("tcp", listener.Addr().String(), true)
+	defer client.Close()
+
+	hungCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		_, _ = client.Do(hungCtx, nil)
+		close(done)
+	}()
+
+	// Wait for the hung request to hold the connection.
+	assert.Eventually(t, func() bool {
+		return len(client.sem) == 1
+	}, time.Second, time.Millisecond)
+
+	ctx, stop := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer stop()
+
+	start := time.Now()
+
+	// Requests waiting for the connection give up once their context is done.
+	_, err = client.Do(ctx, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	cancel()
+	<-done
+}
+
+func TestFastCGIClientReconnect(t *testing.T) {
+	// The responder closes the connection after each request.
+	listener := serveRawFastCGI(t, fakeResponse{
+		stdout: "Content-Type: text/plain\r\n\r\npong",
+	})
+
+	client := NewFastCGIClient("tcp", listener.Addr().String(), true)
+	defer client.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Do(context.Background(), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "pong", string(resp.Body))
+	}
+
+	assert.Equal(t, int64(2), listener.accepted.Load())
+}
+
+func TestFastCGIClientStderr(t *testing.T) {
+	listener := serveRawFastCGI(t, fakeResponse{
+		stdout:    "Status: 404 Not Found\r\nContent-Type: text/html\r\n\r\nFile not found.\n",
+		stderr:    "Primary script unknown",
+		appStatus: 1,
+		keepConn:  true,
+	})
+
+	client := NewFastCGIClient("tcp", listener.Addr().String(), true)
+	defer client.Close()
+
+	resp, err := client.Do(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "File not found.\n", string(resp.Body))
+	assert.Equal(t, "Primary script unknown", string(resp.Stderr))
+	assert.Equal(t, uint32(1), resp.AppStatus)
+
+	_, err = queryStatus(context.Background(), client, ClientConfig{Timeout: time.Second})
+	assert.ErrorContains(t, err, "status code was: 404: Primary script unknown")
+}
+
+func TestFastCGIClientAppStatus(t *testing.T) {
+	listener := serveRawFastCGI(t, fakeResponse{
+		stdout:    "Content-Type: application/json\r\n\r\n{}",
+		stderr:    "PHP Fatal error: Allowed memory size exhausted",
+		appStatus: 255,
+		keepConn:  true,
+	})
+
+	client := NewFastCGIClient("tcp", listener.Addr().String(), true)
+	defer client.Close()
+
+	_, err := queryStatus(context.Background(), client, ClientConfig{Timeout: time.Second})
+	assert.ErrorContains(t, err, "app status was: 255: PHP Fatal error")
+}
+
+func TestFastCGIClientLargeParams(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		_ = fcgi.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(fcgi.ProcessEnv(r)["LARGE"]))
+		}))
+	}()
+
+	client := NewFastCGIClient("tcp", listener.Addr().String(), true)
+	defer client.Close()
+
+	// Larger than a single record, so the params must be split.
+	large := strings.Repeat("x", 100000)
+
+	resp, err := client.Do(context.Background(), map[string]string{
+		"REQUEST_METHOD":  "GET",
+		"SERVER_PROTOCOL": "HTTP/1.1",
+		"LARGE":           large,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(resp.Stderr))
+	assert.Equal(t, large, string(resp.Body))
+}
+
+func TestFastCGIClientDialError(t *testing.T) {
+	client := NewFastCGIClient("unix", socketPath(t), true)
+
+	_, err := client.Do(context.Background(), nil)
+	assert.Error(t, err)
+}
+
+func TestReadResponseMalformed(t *testing.T) {
+	var buf bytes.Buffer
+
+	writeRecord(&buf, fcgiTypeStdout, []byte("Status: abc\r\n\r\n"))
+	writeRecord(&buf, fcgiTypeEndRequest, make([]byte, 8))
+
+	_, err := readResponse(&buf)
+	assert.ErrorContains(t, err, "malformed status header")
+
+	// Request rejected by the responder eg. FCGI_OVERLOADED.
+	buf.Reset()
+	writeRecord(&buf, fcgiTypeEndRequest, []byte{0, 0, 0, 0, 2, 0, 0, 0})
+
+	_, err = readResponse(&buf)
+	assert.ErrorContains(t, err, "protocol status: 2")
+
+	// Truncated stream.
+	buf.Reset()
+	writeRecord(&buf, fcgiTypeStdout, []byte("Content-Type: text/plain\r\n\r\n"))
+
+	_, err = readResponse(&buf)
+	assert.Error(t, err)
+}
+
+func TestEncodePairs(t *testing.T) {
+	pairs := map[string]string{
+		"SCRIPT_NAME": "/status",
+		"EMPTY":       "",
+		"LONG":        strings.Repeat("y", 300),
+	}
+
+	assert.Equal(t, pairs, decodePairs(encodePairs(pairs)))
+}
+
+func FuzzReadResponse(f *testing.F) {
+	var buf bytes.Buffer
+
+	writeStream(&buf, fcgiTypeStdout, []byte("Status: 200 OK\r\nContent-Type: application/json\r\n\r\n{}"))
+	writeStream(&buf, fcgiTypeStderr, []byte("warning"))
+	writeRecord(&buf, fcgiTypeEndRequest, make([]byte, 8))
+
+	f.Add(buf.Bytes())
+	f.Add([]byte{})
+	f.Add([]byte{1, 3, 0, 1, 0, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		resp, err := readResponse(bytes.NewReader(data))
+		if err == nil && resp == nil {
+			t.Fatal("expected a response when there is no error")
+		}
+	})
+}
+
+func FuzzEncodePairs(f *testing.F) {
+	f.Add("SCRIPT_FILENAME", "/status")
+	f.Add("", "")
+	f.Add(strings.Repeat("n", 200), strings.Repeat("v", 70000))
+
+	f.Fuzz(func(t *testing.T, name, value string) {
+		pairs := decodePairs(encodePairs(map[string]string{name: value}))
+		if pairs[name] != value {
+			t.Fatalf("pair did not survive encoding: %q", name)
+		}
+	})
+}
@@ -0,0 +1,253 @@
+package fpm
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// Format of the FPM status page.
+type Format string
+
+const (
+	// FormatJSON selects the status page with query string "json&full".
+	FormatJSON Format = "json"
+	// FormatText selects the plain text status page with query string "full".
+	FormatText Format = "text"
+	// FormatXML selects the status page with query string "xml&full".
+	FormatXML Format = "xml"
+	// FormatOpenMetrics selects the status page with query string "openmetrics" (FPM 8.1+).
+	// This format does not provide the pool name, process manager, start time or processes.
+	FormatOpenMetrics Format = "openmetrics"
+)
+
+// TextTimeFormat used by the plain text status page for start times.
+const TextTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// ParseFormat validates the name of a status page format.
+func ParseFormat(name string) (Format, error) {
+	switch format := Format(name); format {
+	case FormatJSON, FormatText, FormatXML, FormatOpenMetrics:
+		return format, nil
+	}
+
+	return "", fmt.Errorf("unsupported status format: %q", name)
+}
+
+// Query string used to request the status page in this format.
+func (f Format) Query() string {
+	switch f {
+	case FormatText:
+		return "full"
+	case FormatXML:
+		return "xml&full"
+	case FormatOpenMetrics:
+		return "openmetrics"
+	}
+
+	return "json&full"
+}
+
+// ParseStatus from the body of the status page in the given format.
+func ParseStatus(format Format, body []byte) (Status, error) {
+	var (
+		response QueryResponse
+		err      error
+	)
+
+	switch format {
+	case FormatJSON:
+		err = json.Unmarshal(body, &response)
+	case FormatText:
+		response, err = parseText(body)
+	case FormatXML:
+		err = xml.Unmarshal(body, &response)
+	case FormatOpenMetrics:
+		response, err = parseOpenMetrics(body)
+	default:
+		err = fmt.Errorf("unsupported status format: %q", format)
+	}
+
+	if err != nil {
+		return Status{}, fmt.Errorf("failed to decode %s: %w", format, err)
+	}
+
+	return response.Status(), nil
+}
+
+// Helper function to parse the plain text status page.
+// The pool is listed first, followed by each process starting with its pid.
+func parseText(body []byte) (QueryResponse, error) {
+	var response QueryResponse
+
+	for line := range strings.Lines(string(body)) {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			// Blank lines and the separator between processes.
+			continue
+		}
+
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		if key == "pid" {
+			response.Processes = append(response.Processes, QueryProcess{})
+		}
+
+		var err error
+
+		if len(response.Processes) == 0 {
+			err = response.setText(key, value)
+		} else {
+			err = response.Processes[len(response.Processes)-1].setText(key, value)
+		}
+
+		if err != nil {
+			return response, fmt.Errorf("invalid value for %q: %w", key, err)
+		}
+	}
+
+	return response, nil
+}
+
+// Helper function to set a pool field from the plain text status page.
+func (response *QueryResponse) setText(key, value string) error {
+	var err error
+
+	switch key {
+	case "pool":
+		response.Pool = value
+	case "process manager":
+		response.ProcessManager = value
+	case "start time":
+		response.StartTime, err = parseTextTime(value)
+	case "start since":
+		response.StartSince, err = strconv.ParseInt(value, 10, 64)
+	case "accepted conn":
+		response.AcceptedConn, err = strconv.ParseInt(value, 10, 64)
+	case "listen queue":
+		response.ListenQueue, err = strconv.ParseInt(value, 10, 64)
+	case "max listen queue":
+		response.MaxListenQueue, err = strconv.ParseInt(value, 10, 64)
+	case "listen queue len":
+		response.ListenQueueLen, err = strconv.ParseInt(value, 10, 64)
+	case "idle processes":
+		response.IdleProcesses, err = strconv.ParseInt(value, 10, 64)
+	case "active processes":
+		response.ActiveProcesses, err = strconv.ParseInt(value, 10, 64)
+	case "total processes":
+		response.TotalProcesses, err = strconv.ParseInt(value, 10, 64)
+	case "max active processes":
+		response.MaxActiveProcesses, err = strconv.ParseInt(value, 10, 64)
+	case "max children reached":
+		response.MaxChildrenReached, err = strconv.ParseInt(value, 10, 64)
+	case "slow requests":
+		response.SlowRequests, err = strconv.ParseInt(value, 10, 64)
+	}
+
+	return err
+}
+
+// Helper function to set a process field from the plain text status page.
+func (process *QueryProcess) setText(key, value string) error {
+	var err error
+
+	switch key {
+	case "pid":
+		process.Pid, err = strconv.ParseInt(value, 10, 64)
+	case "state":
+		process.State = value
+	case "start time":
+		process.StartTime, err = parseTextTime(value)
+	case "start since":
+		process.StartSince, err = strconv.ParseInt(value, 10, 64)
+	case "requests":
+		process.Requests, err = strconv.ParseInt(value, 10, 64)
+	case "request duration":
+		process.RequestDuration, err = strconv.ParseInt(value, 10, 64)
+	case "request method":
+		process.RequestMethod = value
+	case "request uri":
+		process.RequestURI = value
+	case "content length":
+		process.ContentLength, err = strconv.ParseInt(value, 10, 64)
+	case "user":
+		process.User = value
+	case "script":
+		process.Script = value
+	case "last request cpu":
+		process.LastRequestCPU, err = strconv.ParseFloat(value, 64)
+	case "last request memory":
+		process.LastRequestMemory, err = strconv.ParseInt(value, 10, 64)
+	}
+
+	return err
+}
+
+// Helper function to convert a start time from the plain text status page into a unix timestamp.
+func parseTextTime(value string) (int64, error) {
+	t, err := time.Parse(TextTimeFormat, value)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Unix(), nil
+}
+
+// Helper function to parse the OpenMetrics status page.
+func parseOpenMetrics(body []byte) (QueryResponse, error) {
+	var response QueryResponse
+
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(body))
+	if err != nil {
+		return response, err
+	}
+
+	// Names used by the FPM status page, which differ from the metrics exported by the sidecar.
+	fields := map[string]*int64{
+		"phpfpm_start_since":          &response.StartSince,
+		"phpfpm_accepted_connections": &response.AcceptedConn,
+		"phpfpm_listen_queue":         &response.ListenQueue,
+		"phpfpm_max_listen_queue":     &response.MaxListenQueue,
+		"phpfpm_listen_queue_length":  &response.ListenQueueLen,
+		"phpfpm_idle_processes":       &response.IdleProcesses,
+		"phpfpm_active_processes":     &response.ActiveProcesses,
+		"phpfpm_total_processes":      &response.TotalProcesses,
+		"phpfpm_max_active_processes": &response.MaxActiveProcesses,
+		"phpfpm_max_children_reached": &response.MaxChildrenReached,
+		"phpfpm_slow_requests":        &response.SlowRequests,
+	}
+
+	for name, field := range fields {
+		family, ok := families[name]
+		if !ok || len(family.GetMetric()) == 0 {
+			continue
+		}
+
+		*field = int64(metricValue(family.GetMetric()[0]))
+	}
+
+	return response, nil
+}
+
+// Helper function to get the value of a gauge, counter or untyped metric.
+func metricValue(metric *dto.Metric) float64 {
+	switch {
+	case metric.GetGauge() != nil:
+		return metric.GetGauge().GetValue()
+	case metric.GetCounter() != nil:
+		return metric.GetCounter().GetValue()
+	}
+
+	return metric.GetUntyped().GetValue()
+}
@@ -0,0 +1,114 @@
+package fpm
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestParseStatusGolden(t *testing.T) {
+	tests := map[Format]string{
+		FormatJSON:        "status.json",
+		FormatText:        "status.txt",
+		FormatXML:         "status.xml",
+		FormatOpenMetrics: "status.openmetrics",
+	}
+
+	for format, file := range tests {
+		t.Run(string(format), func(t *testing.T) {
+			body, err := os.ReadFile(filepath.Join("testdata", "status", file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			status, err := ParseStatus(format, body)
+			assert.NoError(t, err)
+
+			actual, err := json.MarshalIndent(status, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", "status", file+".golden")
+
+			if *update {
+				if err := os.WriteFile(golden, append(actual, '\n'), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.JSONEq(t, string(expected), string(actual))
+		})
+	}
+}
+
+func TestParseStatusFormatsMatch(t *testing.T) {
+	var statuses []Status
+
+	for format, file := range map[Format]string{
+		FormatJSON: "status.json",
+		FormatText: "status.txt",
+		FormatXML:  "status.xml",
+	} {
+		body, err := os.ReadFile(filepath.Join("testdata", "status", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		status, err := ParseStatus(format, body)
+		assert.NoError(t, err)
+
+		statuses = append(statuses, status)
+	}
+
+	// Every full format provides the same status.
+	assert.Equal(t, statuses[0], statuses[1])
+	assert.Equal(t, statuses[0], statuses[2])
+}
+
+func TestParseStatusInvalid(t *testing.T) {
+	_, err := ParseStatus(FormatJSON, []byte("pool: www"))
+	assert.ErrorContains(t, err, "failed to decode json")
+
+	_, err = ParseStatus(FormatText, []byte("listen queue: lots"))
+	assert.ErrorContains(t, err, `invalid value for "listen queue"`)
+
+	_, err = ParseStatus(FormatText, []byte("start time: yesterday"))
+	assert.ErrorContains(t, err, `invalid value for "start time"`)
+
+	_, err = ParseStatus(FormatXML, []byte("<status><pool>www</pool>"))
+	assert.ErrorContains(t, err, "failed to decode xml")
+
+	_, err = ParseStatus(FormatOpenMetrics, []byte("phpfpm_listen_queue lots"))
+	assert.ErrorContains(t, err, "failed to decode openmetrics")
+
+	_, err = ParseStatus("html", nil)
+	assert.ErrorContains(t, err, `unsupported status format: "html"`)
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, name := range []string{"json", "text", "xml", "openmetrics"} {
+		format, err := ParseFormat(name)
+		assert.NoError(t, err)
+		assert.Equal(t, Format(name), format)
+	}
+
+	_, err := ParseFormat("html")
+	assert.Error(t, err)
+
+	assert.Equal(t, "json&full", FormatJSON.Query())
+	assert.Equal(t, "full", FormatText.Query())
+	assert.Equal(t, "xml&full", FormatXML.Query())
+	assert.Equal(t, "openmetrics", FormatOpenMetrics.Query())
+}
@@ -2,55 +2,198 @@
 package fpm
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
 	"fmt"
-	"time"
+	"maps"
+	"net/http"
+	"strings"
+)
+
+const (
+	// SchemeTCP is used to select a TCP connection to FPM.
+	SchemeTCP = "tcp://"
+	// SchemeUnix is used to select a unix socket connection to FPM.
+	SchemeUnix = "unix://"
 
-	fcgiclient "github.com/tomasen/fcgi_client"
+	// DefaultStatusPath used by FPM when pm.status_path is enabled.
+	DefaultStatusPath = "/status"
+	// DefaultPingPath used by FPM when ping.path is enabled.
+	DefaultPingPath = "/ping"
+	// DefaultPingResponse used by FPM when ping.response is not set.
+	DefaultPingResponse = "pong"
 )
 
-func NewFpmTcpClient(address string, timeout time.Duration) *FpmTcpClient {
+// NewClient returns a client for the given endpoint.
+// Endpoints prefixed with unix:// will use a unix socket, otherwise TCP is used.
+func NewClient(endpoint string, config ClientConfig) (FcmClient, error) {
+	if config.Format != "" {
+		if _, err := ParseFormat(string(config.Format)); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(endpoint, SchemeUnix):
+		path := strings.TrimPrefix(endpoint, SchemeUnix)
+		if path == "" {
+			return nil, fmt.Errorf("socket path not provided: %s", endpoint)
+		}
+
+		return NewFpmUnixClient(path, config), nil
+	case strings.HasPrefix(endpoint, SchemeTCP):
+		return NewFpmTcpClient(strings.TrimPrefix(endpoint, SchemeTCP), config), nil
+	case strings.Contains(endpoint, "://"):
+		return nil, fmt.Errorf("unsupported endpoint scheme: %s", endpoint)
+	}
+
+	return NewFpmTcpClient(endpoint, config), nil
+}
+
+func NewFpmTcpClient(address string, config ClientConfig) *FpmTcpClient {
 	return &FpmTcpClient{
-		Address: address,
-		Timeout: timeout,
+		ClientConfig: config.withDefaults(),
+		Address:      address,
+		fcgi:         NewFastCGIClient("tcp", address, !config.DisableKeepAlive),
 	}
 }
 
 // QueryStatus of the FPM worker pool.
-func (client *FpmTcpClient) QueryStatus() (Status, error) {
-	var status Status
+func (client *FpmTcpClient) QueryStatus(ctx context.Context) (Status, error) {
+	return queryStatus(ctx, client.fcgi, client.ClientConfig)
+}
+
+// Ping the FPM worker pool.
+func (client *FpmTcpClient) Ping(ctx context.Context) error {
+	return ping(ctx, client.fcgi, client.ClientConfig)
+}
+
+func NewFpmUnixClient(path string, config ClientConfig) *FpmUnixClient {
+	return &FpmUnixClient{
+		ClientConfig: config.withDefaults(),
+		Path:         path,
+		fcgi:         NewFastCGIClient("unix", path, !config.DisableKeepAlive),
+	}
+}
+
+// QueryStatus of the FPM worker pool.
+func (client *FpmUnixClient) QueryStatus(ctx context.Context) (Status, error) {
+	return queryStatus(ctx, client.fcgi, client.ClientConfig)
+}
+
+// Ping the FPM worker pool.
+func (client *FpmUnixClient) Ping(ctx context.Context) error {
+	return ping(ctx, client.fcgi, client.ClientConfig)
+}
+
+// Helper function to fill in the FPM defaults for unset config.
+func (config ClientConfig) withDefaults() ClientConfig {
+	if config.Format == "" {
+		config.Format = FormatJSON
+	}
+
+	if config.StatusPath == "" {
+		config.StatusPath = DefaultStatusPath
+	}
+
+	if config.PingPath == "" {
+		config.PingPath = DefaultPingPath
+	}
 
-	env := map[string]string{
-		"SCRIPT_FILENAME": "/status",
-		"SCRIPT_NAME":     "/status",
-		"QUERY_STRING":    "json",
+	if config.PingResponse == "" {
+		config.PingResponse = DefaultPingResponse
 	}
 
-	fcgi, err := fcgiclient.DialTimeout("tcp", client.Address, client.Timeout)
+	return config
+}
+
+// Helper function to query the FPM status.
+func queryStatus(ctx context.Context, fcgi *FastCGIClient, config ClientConfig) (Status, error) {
+	body, err := get(ctx, fcgi, config, config.StatusPath, config.Format.Query())
 	if err != nil {
-		return status, err
+		return Status{}, err
 	}
-	defer fcgi.Close()
 
-	resp, err := fcgi.Get(env)
+	return ParseStatus(config.Format, body)
+}
+
+// Helper function to ping FPM and check that it responds with the expected response.
+func ping(ctx context.Context, fcgi *FastCGIClient, config ClientConfig) error {
+	body, err := get(ctx, fcgi, config, config.PingPath, "")
 	if err != nil {
-		return status, err
+		return err
+	}
+
+	if response := string(bytes.TrimSpace(body)); response != config.PingResponse {
+		return fmt.Errorf("unexpected ping response: %q", response)
 	}
 
-	defer func() {
-		err = resp.Body.Close()
-	}()
+	return nil
+}
+
+// Helper function to send a GET request for the given path to FPM, returning the body.
+// The timeout applies to the whole request, not just the dial.
+func get(ctx context.Context, fcgi *FastCGIClient, config ClientConfig, path, query string) ([]byte, error) {
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
 
-	if resp.StatusCode != 200 && resp.StatusCode != 0 {
-		return status, fmt.Errorf("status code was: %d", resp.StatusCode)
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
 	}
 
-	var response QueryResponse
+	// Extra params cannot override the params which describe this request.
+	env := maps.Clone(config.Params)
+	if env == nil {
+		env = make(map[string]string)
+	}
 
-	err = json.NewDecoder(resp.Body).Decode(&response)
+	maps.Copy(env, map[string]string{
+		"SCRIPT_FILENAME": path,
+		"SCRIPT_NAME":     path,
+		"QUERY_STRING":    query,
+		"REQUEST_METHOD":  "GET",
+		"CONTENT_LENGTH":  "0",
+		"SERVER_PROTOCOL": "HTTP/1.1",
+	})
+
+	resp, err := fcgi.Do(ctx, env)
 	if err != nil {
-		return status, fmt.Errorf("failed to decode json: %w", err)
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code was: %d: %s", resp.StatusCode, bytes.TrimSpace(resp.Stderr))
+	}
+
+	if resp.AppStatus != 0 {
+		return nil, fmt.Errorf("app status was: %d: %s", resp.AppStatus, bytes.TrimSpace(resp.Stderr))
+	}
+
+	return resp.Body, nil
+}
+
+// Status converts the query response into our Status struct.
+func (response QueryResponse) Status() Status {
+	status := Status{
+		Pool:               response.Pool,
+		ProcessManager:     response.ProcessManager,
+		StartTime:          response.StartTime,
+		StartSince:         response.StartSince,
+		AcceptedConn:       response.AcceptedConn,
+		ListenQueue:        response.ListenQueue,
+		MaxListenQueue:     response.MaxListenQueue,
+		ListenQueueLen:     response.ListenQueueLen,
+		IdleProcesses:      response.IdleProcesses,
+		ActiveProcesses:    response.ActiveProcesses,
+		TotalProcesses:     response.TotalProcesses,
+		MaxActiveProcesses: response.MaxActiveProcesses,
+		MaxChildrenReached: response.MaxChildrenReached,
+		SlowRequests:       response.SlowRequests,
+	}
+
+	for _, process := range response.Processes {
+		status.Processes = append(status.Processes, Process(process))
 	}
 
-	return Status(response), nil
+	return status
 }
@@ -0,0 +1,424 @@
+package fpm
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const statusResponse = `{
+  "pool": "www",
+  "process manager": "dynamic",
+  "start time": 1700000000,
+  "start since": 3600,
+  "accepted conn": 1234,
+  "listen queue": 1,
+  "max listen queue": 9,
+  "listen queue len": 511,
+  "idle processes": 2,
+  "active processes": 3,
+  "total processes": 5,
+  "max active processes": 4,
+  "max children reached": 2,
+  "slow requests": 6,
+  "processes": [
+    {
+      "pid": 10,
+      "state": "Idle",
+      "start time": 1700000000,
+      "start since": 120,
+      "requests": 42,
+      "request duration": 1500,
+      "request method": "GET",
+      "request uri": "/index.php",
+      "content length": 0,
+      "user": "-",
+      "script": "/data/app/index.php",
+      "last request cpu": 12.5,
+      "last request memory": 2097152
+    },
+    {
+      "pid": 11,
+      "state": "Running",
+      "start time": 1700000001,
+      "start since": 119,
+      "requests": 7,
+      "request duration": 30000000,
+      "request method": "POST",
+      "request uri": "/cron.php",
+      "content length": 128,
+      "user": "admin",
+      "script": "/data/app/cron.php",
+      "last request cpu": 0,
+      "last request memory": 0
+    }
+  ]
+}`
+
+// serveFakeFpm starts a FastCGI listener which responds with the given status.
+func serveFakeFpm(t *testing.T, listener net.Listener, code int, body string) {
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		_ = fcgi.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			_, _ = w.Write([]byte(body))
+		}))
+	}()
+}
+
+// socketPath returns a short socket path, avoiding the unix socket path length limit.
+func socketPath(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "fpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	return filepath.Join(dir, "fpm.sock")
+}
+
+func TestQueryStatusTcp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serveFakeFpm(t, listener, http.StatusOK, statusResponse)
+
+	status, err := NewFpmTcpClient(listener.Addr().String(), ClientConfig{Timeout: time.Second}).QueryStatus(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "dynamic", status.ProcessManager)
+	assert.Equal(t, int64(3), status.ActiveProcesses)
+	assert.Equal(t, int64(511), status.ListenQueueLen)
+}
+
+func TestQueryStatusPool(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serveFakeFpm(t, listener, http.StatusOK, statusResponse)
+
+	status, err := NewFpmTcpClient(listener.Addr().String(), ClientConfig{Timeout: time.Second}).QueryStatus(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "www", status.Pool)
+	assert.Equal(t, int64(1700000000), status.StartTime)
+	assert.Equal(t, int64(3600), status.StartSince)
+	assert.Equal(t, int64(1234), status.AcceptedConn)
+	assert.Equal(t, int64(9), status.MaxListenQueue)
+	assert.Equal(t, int64(2), status.MaxChildrenReached)
+	assert.Equal(t, int64(6), status.SlowRequests)
+}
+
+func TestQueryStatusUnix(t *testing.T) {
+	path := socketPath(t)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serveFakeFpm(t, listener, http.StatusOK, statusResponse)
+
+	client, err := NewClient(SchemeUnix+path, ClientConfig{Timeout: time.Second})
+	assert.NoError(t, err)
+	assert.IsType(t, &FpmUnixClient{}, client)
+
+	status, err := client.QueryStatus(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), status.ListenQueue)
+	assert.Equal(t, int64(2), status.IdleProcesses)
+	assert.Equal(t, int64(5), status.TotalProcesses)
+	assert.Equal(t, int64(4), status.MaxActiveProcesses)
+}
+
+func TestQueryStatusProcesses(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serveFakeFpm(t, listener, http.StatusOK, statusResponse)
+
+	status, err := NewFpmTcpClient(listener.Addr().String(), ClientConfig{Timeout: time.Second}).QueryStatus(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []Process{
+		{
+			Pid:               10,
+			State:             "Idle",
+			StartTime:         1700000000,
+			StartSince:        120,
+			Requests:          42,
+			RequestDuration:   1500,
+			RequestMethod:     "GET",
+			RequestURI:        "/index.php",
+			User:              "-",
+			Script:            "/data/app/index.php",
+			LastRequestCPU:    12.5,
+			LastRequestMemory: 2097152,
+		},
+		{
+			Pid:             11,
+			State:           "Running",
+			StartTime:       1700000001,
+			StartSince:      119,
+			Requests:        7,
+			RequestDuration: 30000000,
+			RequestMethod:   "POST",
+			RequestURI:      "/cron.php",
+			ContentLength:   128,
+			User:            "admin",
+			Script:          "/data/app/cron.php",
+		},
+	}, status.Processes)
+	assert.False(t, status.Processes[0].Busy())
+	assert.True(t, status.Processes[1].Busy())
+}
+
+func TestQueryStatusUnixNotFound(t *testing.T) {
+	_, err := NewFpmUnixClient(socketPath(t), ClientConfig{Timeout: time.Second}).QueryStatus(context.Background())
+	assert.Error(t, err)
+}
+
+func TestQueryStatusErrorCode(t *testing.T) {
+	path := socketPath(t)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serveFakeFpm(t, listener, http.StatusForbidden, "Access denied.")
+
+	_, err = NewFpmUnixClient(path, ClientConfig{Timeout: time.Second}).QueryStatus(context.Background())
+	assert.ErrorContains(t, err, "status code was: 403")
+}
+
+// assertFastCGIClient asserts which network and address a client sends requests to.
+func assertFastCGIClient(t *testing.T, client FcmClient, network, address string) {
+	t.Helper()
+
+	var fcgi *FastCGIClient
+
+	switch client := client.(type) {
+	case *FpmTcpClient:
+		assert.Equal(t, ClientConfig{Timeout: time.Second}.withDefaults(), client.ClientConfig)
+		assert.Equal(t, address, client.Address)
+		fcgi = client.fcgi
+	case *FpmUnixClient:
+		assert.Equal(t, ClientConfig{Timeout: time.Second}.withDefaults(), client.ClientConfig)
+		assert.Equal(t, address, client.Path)
+		fcgi = client.fcgi
+	default:
+		t.Fatalf("unexpected client type: %T", client)
+	}
+
+	assert.Equal(t, network, fcgi.network)
+	assert.Equal(t, address, fcgi.address)
+	assert.True(t, fcgi.keepAlive)
+}
+
+func TestNewClient(t *testing.T) {
+	client, err := NewClient("127.0.0.1:9000", ClientConfig{Timeout: time.Second})
+	assert.NoError(t, err)
+	assertFastCGIClient(t, client, "tcp", "127.0.0.1:9000")
+
+	client, err = NewClient("tcp://127.0.0.1:9000", ClientConfig{Timeout: time.Second})
+	assert.NoError(t, err)
+	assertFastCGIClient(t, client, "tcp", "127.0.0.1:9000")
+
+	client, err = NewClient("unix:///run/php-fpm.sock", ClientConfig{Timeout: time.Second})
+	assert.NoError(t, err)
+	assertFastCGIClient(t, client, "unix", "/run/php-fpm.sock")
+
+	_, err = NewClient("unix://", ClientConfig{Timeout: time.Second})
+	assert.Error(t, err)
+
+	_, err = NewClient("http://127.0.0.1:9000", ClientConfig{Timeout: time.Second})
+	assert.Error(t, err)
+}
+
+func TestStatusUtilization(t *testing.T) {
+	status := Status{
+		ListenQueue:     64,
+		ListenQueueLen:  512,
+		ActiveProcesses: 7,
+		TotalProcesses:  10,
+	}
+
+	assert.Equal(t, 0.7, status.ProcessUtilization())
+	assert.Equal(t, 0.125, status.ListenQueueUtilization())
+
+	// Avoid dividing by zero eg. when the listen queue len is not reported.
+	assert.Equal(t, float64(0), Status{ListenQueue: 1}.ListenQueueUtilization())
+	assert.Equal(t, float64(0), Status{ActiveProcesses: 1}.ProcessUtilization())
+}
+
+func TestQueryStatusContext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	// Accept connections but never respond, like a hung FPM.
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			t.Cleanup(func() {
+				_ = conn.Close()
+			})
+		}
+	}()
+
+	client := NewFpmTcpClient(listener.Addr().String(), ClientConfig{Timeout: time.Minute})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	_, err = client.QueryStatus(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// The client timeout bounds the whole query, not just the dial.
+	client.Timeout = 50 * time.Millisecond
+
+	_, err = client.QueryStatus(context.Background())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestPing(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		_ = fcgi.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != DefaultPingPath {
+				http.NotFound(w, r)
+				return
+			}
+
+			_, _ = w.Write([]byte("pong\n"))
+		}))
+	}()
+
+	address := listener.Addr().String()
+
+	assert.NoError(t, NewFpmTcpClient(address, ClientConfig{Timeout: time.Second}).Ping(context.Background()))
+
+	err = NewFpmTcpClient(address, ClientConfig{Timeout: time.Second, PingResponse: "ok"}).Ping(context.Background())
+	assert.ErrorContains(t, err, `unexpected ping response: "pong"`)
+
+	err = NewFpmTcpClient(address, ClientConfig{Timeout: time.Second, PingPath: "/healthz"}).Ping(context.Background())
+	assert.ErrorContains(t, err, "status code was: 404")
+}
+
+func TestQueryStatusFormat(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	// Respond with the plain text status page when the format is requested.
+	go func() {
+		_ = fcgi.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.RawQuery != FormatText.Query() {
+				http.Error(w, "JSON is disabled", http.StatusForbidden)
+				return
+			}
+
+			_, _ = w.Write([]byte("pool: www\nlisten queue: 12\n"))
+		}))
+	}()
+
+	status, err := NewFpmTcpClient(listener.Addr().String(), ClientConfig{Timeout: time.Second, Format: FormatText}).QueryStatus(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "www", status.Pool)
+	assert.Equal(t, int64(12), status.ListenQueue)
+
+	_, err = NewClient(listener.Addr().String(), ClientConfig{Format: "html"})
+	assert.ErrorContains(t, err, "unsupported status format")
+}
+
+func TestQueryStatusPathAndParams(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		_ = fcgi.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			env := fcgi.ProcessEnv(r)
+
+			switch {
+			case r.URL.Path != "/fpm-status":
+				http.NotFound(w, r)
+			case env["SCRIPT_FILENAME"] != "/fpm-status":
+				http.Error(w, "unexpected script filename", http.StatusBadRequest)
+			case env["SERVER_NAME"] != "localhost" || !strings.HasPrefix(r.RemoteAddr, "10.0.0.1"):
+				http.Error(w, "access denied", http.StatusForbidden)
+			default:
+				_, _ = w.Write([]byte(statusResponse))
+			}
+		}))
+	}()
+
+	address := listener.Addr().String()
+
+	config := ClientConfig{
+		Timeout:    time.Second,
+		StatusPath: "/fpm-status",
+		Params: map[string]string{
+			"SERVER_NAME": "localhost",
+			"REMOTE_ADDR": "10.0.0.1",
+			// Cannot override the params which describe the request.
+			"SCRIPT_FILENAME": "/index.php",
+		},
+	}
+
+	status, err := NewFpmTcpClient(address, config).QueryStatus(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "www", status.Pool)
+
+	_, err = NewFpmTcpClient(address, ClientConfig{Timeout: time.Second}).QueryStatus(context.Background())
+	assert.ErrorContains(t, err, "status code was: 404")
+}
@@ -1,36 +1,103 @@
 package fpm
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type FcmClient interface {
-	QueryStatus() (Status, error)
+	QueryStatus(ctx context.Context) (Status, error)
+	Ping(ctx context.Context) error
+}
+
+// ClientConfig used when sending requests to FPM.
+type ClientConfig struct {
+	// Timeout for each request to FPM.
+	Timeout time.Duration
+	// Format of the status page, defaults to JSON.
+	Format Format
+	// StatusPath which FPM responds to with the status page (pm.status_path).
+	StatusPath string
+	// PingPath which FPM responds to with the ping response (ping.path).
+	PingPath string
+	// PingResponse which FPM responds with when pinged (ping.response).
+	PingResponse string
+	// Params which are passed through to FPM with each request eg. SERVER_NAME or REMOTE_ADDR.
+	Params map[string]string
+	// DisableKeepAlive opens a new connection for each request.
+	// Otherwise the connection is reused, which pins one FPM worker of the pool between requests.
+	DisableKeepAlive bool
 }
 
 // FpmTcpClient provides a TCP connection to the FPM status endpoint.
 type FpmTcpClient struct {
+	ClientConfig
 	Address string
-	Timeout time.Duration
+	fcgi    *FastCGIClient
+}
+
+// FpmUnixClient provides a unix socket connection to the FPM status endpoint.
+type FpmUnixClient struct {
+	ClientConfig
+	Path string
+	fcgi *FastCGIClient
 }
 
-// QueryResponse provided by the FPM status request with query string "json&full".
+// QueryResponse provided by the FPM status request with query string "json&full" or "xml&full".
 // This is a temporay struct and is marshalled into our Status struct.
 // https://www.php.net/manual/en/fpm.status.php
 type QueryResponse struct {
-	ProcessManager     string `json:"process manager"`
-	ListenQueue        int64  `json:"listen queue"`
-	ListenQueueLen     int64  `json:"listen queue len"`
-	IdleProcesses      int64  `json:"idle processes"`
-	ActiveProcesses    int64  `json:"active processes"`
-	TotalProcesses     int64  `json:"total processes"`
-	MaxActiveProcesses int64  `json:"max active processes"`
+	Pool               string `json:"pool" xml:"pool"`
+	ProcessManager     string `json:"process manager" xml:"process-manager"`
+	StartTime          int64  `json:"start time" xml:"start-time"`
+	StartSince         int64  `json:"start since" xml:"start-since"`
+	AcceptedConn       int64  `json:"accepted conn" xml:"accepted-conn"`
+	ListenQueue        int64  `json:"listen queue" xml:"listen-queue"`
+	MaxListenQueue     int64  `json:"max listen queue" xml:"max-listen-queue"`
+	ListenQueueLen     int64  `json:"listen queue len" xml:"listen-queue-len"`
+	IdleProcesses      int64  `json:"idle processes" xml:"idle-processes"`
+	ActiveProcesses    int64  `json:"active processes" xml:"active-processes"`
+	TotalProcesses     int64  `json:"total processes" xml:"total-processes"`
+	MaxActiveProcesses int64  `json:"max active processes" xml:"max-active-processes"`
+	MaxChildrenReached int64  `json:"max children reached" xml:"max-children-reached"`
+	SlowRequests       int64  `json:"slow requests" xml:"slow-requests"`
+	// Only provided when the "full" query string is used.
+	Processes []QueryProcess `json:"processes" xml:"processes>process"`
+}
+
+// QueryProcess provided by the FPM status request for each process in the pool.
+type QueryProcess struct {
+	Pid               int64   `json:"pid" xml:"pid"`
+	State             string  `json:"state" xml:"state"`
+	StartTime         int64   `json:"start time" xml:"start-time"`
+	StartSince        int64   `json:"start since" xml:"start-since"`
+	Requests          int64   `json:"requests" xml:"requests"`
+	RequestDuration   int64   `json:"request duration" xml:"request-duration"`
+	RequestMethod     string  `json:"request method" xml:"request-method"`
+	RequestURI        string  `json:"request uri" xml:"request-uri"`
+	ContentLength     int64   `json:"content length" xml:"content-length"`
+	User              string  `json:"user" xml:"user"`
+	Script            string  `json:"script" xml:"script"`
+	LastRequestCPU    float64 `json:"last request cpu" xml:"last-request-cpu"`
+	LastRequestMemory int64   `json:"last request memory" xml:"last-request-memory"`
 }
 
 // Status of the FPM pool.
 type Status struct {
+	// The name of the FPM process pool.
+	Pool string `json:"phpfpm_pool"`
 	// The process manager type - static, dynamic or ondemand.
 	ProcessManager string `json:"phpfpm_process_manager"`
+	// The date/time that the process pool was last started (unix timestamp).
+	StartTime int64 `json:"phpfpm_start_time"`
+	// The time in seconds since the process pool was last started.
+	StartSince int64 `json:"phpfpm_start_since"`
+	// The total number of accepted connections.
+	AcceptedConn int64 `json:"phpfpm_accepted_conn"`
 	// The number of requests (backlog) currently waiting for a free process.
 	ListenQueue int64 `json:"phpfpm_listen_queue"`
+	// The maximum number of requests seen in the listen queue at any one time.
+	MaxListenQueue int64 `json:"phpfpm_max_listen_queue"`
 	// The maximum allowed size of the listen queue.
 	ListenQueueLen int64 `json:"phpfpm_listen_queue_len"`
 	// The number of processes that are currently idle (waiting for requests).
@@ -41,9 +108,91 @@ type Status struct {
 	TotalProcesses int64 `json:"phpfpm_total_processes"`
 	// The maximum number of concurrently active processes.
 	MaxActiveProcesses int64 `json:"phpfpm_max_active_processes"`
+	// The number of times that pm.max_children has been reached.
+	MaxChildrenReached int64 `json:"phpfpm_max_children_reached"`
+	// The total number of requests that have hit the configured request_slowlog_timeout.
+	SlowRequests int64 `json:"phpfpm_slow_requests"`
+	// The processes which belong to the pool.
+	Processes []Process `json:"phpfpm_processes"`
+}
+
+// ProcessUtilization is the ratio of active processes to the number of processes in the pool.
+// Only a measure of saturation for static pools, where the total number of processes is equal to pm.max_children.
+// See PoolConfig.ProcessUtilization.
+func (s Status) ProcessUtilization() float64 {
+	return ratio(s.ActiveProcesses, s.TotalProcesses)
+}
+
+// ListenQueueUtilization is the ratio of requests waiting in the listen queue to the size of the listen queue.
+func (s Status) ListenQueueUtilization() float64 {
+	return ratio(s.ListenQueue, s.ListenQueueLen)
+}
+
+// Helper function to calculate a ratio, avoiding division by zero.
+func ratio(value, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+
+	return float64(value) / float64(total)
+}
+
+// Process within the FPM pool.
+type Process struct {
+	// The PID of the process.
+	Pid int64 `json:"pid"`
+	// The state of the process - Idle, Running, Reading headers, Info, Finishing or Ending.
+	State string `json:"state"`
+	// The date/time that the process started (unix timestamp).
+	StartTime int64 `json:"start_time"`
+	// The number of seconds since the process started.
+	StartSince int64 `json:"start_since"`
+	// The total number of requests served by the process.
+	Requests int64 `json:"requests"`
+	// The total time in microseconds spent serving last request.
+	RequestDuration int64 `json:"request_duration"`
+	// The HTTP method of the last served request.
+	RequestMethod string `json:"request_method"`
+	// The URI of the last served request (after webserver processing, it may always be /index.php if you use a front controller pattern redirect).
+	RequestURI string `json:"request_uri"`
+	// The length of the request body, in bytes, of the last request.
+	ContentLength int64 `json:"content_length"`
+	// The HTTP user (PHP_AUTH_USER) of the last served request.
+	User string `json:"user"`
+	// The full path of the script executed by the last served request.
+	Script string `json:"script"`
+	// The %cpu of the last served request. Reported as 0 if the process is not Idle.
+	LastRequestCPU float64 `json:"last_request_cpu"`
+	// The amount of memory consumed by the last served request. Reported as 0 if the process is not Idle.
+	LastRequestMemory int64 `json:"last_request_memory"`
+}
+
+// Busy reports if the process is currently handling a request.
+func (p Process) Busy() bool {
+	return p.State != ProcessStateIdle
 }
 
 const (
+	// MetricUp provides whether the last query of the FPM status was successful.
+	MetricUp = "phpfpm_up"
+	// MetricLastSuccessfulScrape provides the unix timestamp of the last successful query of the FPM status.
+	MetricLastSuccessfulScrape = "phpfpm_last_successful_scrape_timestamp_seconds"
+	// MetricScrapeErrors provides the total number of failed queries of the FPM status.
+	MetricScrapeErrors = "phpfpm_scrape_errors_total"
+	// MetricPoolInfo provides the name and process manager type of the pool.
+	MetricPoolInfo = "phpfpm_pool_info"
+	// MetricStartTime provides the date/time that the process pool was last started.
+	MetricStartTime = "phpfpm_start_time"
+	// MetricStartSince provides the time in seconds since the process pool was last started.
+	MetricStartSince = "phpfpm_start_since"
+	// MetricAcceptedConnections provides the total number of accepted connections.
+	MetricAcceptedConnections = "phpfpm_accepted_connections_total"
+	// MetricMaxListenQueue provides the maximum number of requests seen in the listen queue at any one time.
+	MetricMaxListenQueue = "phpfpm_max_listen_queue"
+	// MetricMaxChildrenReached provides the number of times that pm.max_children has been reached.
+	MetricMaxChildrenReached = "phpfpm_max_children_reached_total"
+	// MetricSlowRequests provides the total number of requests that have hit the configured request_slowlog_timeout.
+	MetricSlowRequests = "phpfpm_slow_requests_total"
 	// MetricListenQueue provides the number of requests (backlog) currently waiting for a free process.
 	MetricListenQueue = "phpfpm_listen_queue"
 	// MetricListenQueueLen provides the maximum allowed size of the listen queue.
@@ -56,4 +205,55 @@ const (
 	MetricTotalProcesses = "phpfpm_total_processes"
 	// MetricMaxActiveProcesses provides the maximum number of concurrently active processes.
 	MetricMaxActiveProcesses = "phpfpm_max_active_processes"
+
+	// MetricProcessUtilization provides the ratio of active processes to pm.max_children.
+	MetricProcessUtilization = "phpfpm_process_utilization"
+	// MetricListenQueueUtilization provides the ratio of requests in the listen queue to the size of the listen queue.
+	MetricListenQueueUtilization = "phpfpm_listen_queue_utilization"
+
+	// MetricListenQueueAvg provides the average listen queue over a window.
+	MetricListenQueueAvg = "phpfpm_listen_queue_avg"
+	// MetricListenQueueP95 provides the 95th percentile listen queue over a window.
+	MetricListenQueueP95 = "phpfpm_listen_queue_p95"
+	// MetricListenQueueMax provides the maximum listen queue over a window.
+	MetricListenQueueMax = "phpfpm_listen_queue_max"
+	// MetricActiveProcessesAvg provides the average number of active processes over a window.
+	MetricActiveProcessesAvg = "phpfpm_active_processes_avg"
+	// MetricActiveProcessesP95 provides the 95th percentile number of active processes over a window.
+	MetricActiveProcessesP95 = "phpfpm_active_processes_p95"
+	// MetricActiveProcessesMax provides the maximum number of active processes over a window.
+	MetricActiveProcessesMax = "phpfpm_active_processes_max"
+
+	// MetricConfigInfo provides the configured process manager type (pm) of the pool.
+	MetricConfigInfo = "phpfpm_pm_info"
+	// MetricConfigMaxChildren provides the configured pm.max_children of the pool.
+	MetricConfigMaxChildren = "phpfpm_pm_max_children"
+	// MetricConfigStartServers provides the configured pm.start_servers of the pool.
+	MetricConfigStartServers = "phpfpm_pm_start_servers"
+	// MetricConfigMinSpareServers provides the configured pm.min_spare_servers of the pool.
+	MetricConfigMinSpareServers = "phpfpm_pm_min_spare_servers"
+	// MetricConfigMaxSpareServers provides the configured pm.max_spare_servers of the pool.
+	MetricConfigMaxSpareServers = "phpfpm_pm_max_spare_servers"
+	// MetricConfigMaxRequests provides the configured pm.max_requests of the pool.
+	MetricConfigMaxRequests = "phpfpm_pm_max_requests"
+
+	// MetricProcessState provides the current state of each process.
+	MetricProcessState = "phpfpm_process_state"
+	// MetricProcessRequests provides the number of requests served by each process.
+	MetricProcessRequests = "phpfpm_process_requests"
+	// MetricProcessRequestDuration provides the duration in microseconds of the last request served by each process.
+	MetricProcessRequestDuration = "phpfpm_process_request_duration"
+	// MetricProcessLastRequestCPU provides the %cpu of the last request served by each process.
+	MetricProcessLastRequestCPU = "phpfpm_process_last_request_cpu"
+	// MetricProcessLastRequestMemory provides the memory consumed by the last request served by each process.
+	MetricProcessLastRequestMemory = "phpfpm_process_last_request_memory"
+	// MetricProcessesByState provides the number of processes in each state.
+	MetricProcessesByState = "phpfpm_processes_by_state"
+	// MetricScriptBusyProcesses provides the number of busy processes for each script.
+	MetricScriptBusyProcesses = "phpfpm_script_busy_processes"
+)
+
+const (
+	// ProcessStateIdle is reported when a process is waiting for requests.
+	ProcessStateIdle = "Idle"
 )
@@ -0,0 +1,81 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Aggregation used when multiple series match the metric selector.
+type Aggregation string
+
+const (
+	// AggregationNone requires exactly one series to match.
+	AggregationNone Aggregation = ""
+	// AggregationSum adds the values of all matching series.
+	AggregationSum Aggregation = "sum"
+	// AggregationMax returns the largest value of all matching series.
+	AggregationMax Aggregation = "max"
+	// AggregationMin returns the smallest value of all matching series.
+	AggregationMin Aggregation = "min"
+	// AggregationAvg returns the mean value of all matching series.
+	AggregationAvg Aggregation = "avg"
+)
+
+// ParseAggregation from a string eg. an annotation value.
+func ParseAggregation(value string) (Aggregation, error) {
+	switch aggregation := Aggregation(value); aggregation {
+	case AggregationNone, AggregationSum, AggregationMax, AggregationMin, AggregationAvg:
+		return aggregation, nil
+	}
+
+	return AggregationNone, fmt.Errorf("unsupported aggregation: %q", value)
+}
+
+// Apply the aggregation to a set of values.
+func (a Aggregation) Apply(values []float64) (float64, error) {
+	if len(values) == 0 {
+		return 0, errors.New("no values to aggregate")
+	}
+
+	switch a {
+	case AggregationNone:
+		if len(values) > 1 {
+			return 0, fmt.Errorf("found %d values, expected 1", len(values))
+		}
+
+		return values[0], nil
+	case AggregationSum:
+		var sum float64
+
+		for _, value := range values {
+			sum += value
+		}
+
+		return sum, nil
+	case AggregationMax:
+		result := values[0]
+
+		for _, value := range values[1:] {
+			result = max(result, value)
+		}
+
+		return result, nil
+	case AggregationMin:
+		result := values[0]
+
+		for _, value := range values[1:] {
+			result = min(result, value)
+		}
+
+		return result, nil
+	case AggregationAvg:
+		sum, err := AggregationSum.Apply(values)
+		if err != nil {
+			return 0, err
+		}
+
+		return sum / float64(len(values)), nil
+	}
+
+	return 0, fmt.Errorf("unsupported aggregation: %q", string(a))
+}
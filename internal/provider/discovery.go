@@ -0,0 +1,203 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/skpr/fpm-metrics-adapter/internal/fpm"
+)
+
+// AnnotationDiscovery is used for opting a pod into metric discovery eg. fpm.skpr.io/discover=true
+// Metrics exported by discovered pods are advertised as metrics, in addition to the rules.
+const AnnotationDiscovery = "fpm.skpr.io/discover"
+
+// DiscoveryConfig for discovering metrics exported by pods.
+type DiscoveryConfig struct {
+	// Interval for discovering metrics. Disabled when zero.
+	Interval time.Duration
+	// Allow is a list of regular expressions which discovered metric names must match. All metrics are allowed when empty.
+	Allow []string
+	// Deny is a list of regular expressions for metric names which are never advertised.
+	Deny []string
+}
+
+// Filter for discovered metric names.
+type metricFilter struct {
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+// Helper function to compile the allowlist and denylist.
+// Expressions are anchored so they must match the whole metric name.
+func newMetricFilter(config DiscoveryConfig) (metricFilter, error) {
+	var (
+		filter metricFilter
+		err    error
+	)
+
+	filter.allow, err = compilePatterns(config.Allow)
+	if err != nil {
+		return metricFilter{}, fmt.Errorf("invalid allow pattern: %w", err)
+	}
+
+	filter.deny, err = compilePatterns(config.Deny)
+	if err != nil {
+		return metricFilter{}, fmt.Errorf("invalid deny pattern: %w", err)
+	}
+
+	return filter, nil
+}
+
+// Helper function to compile anchored regular expressions, skipping empty patterns.
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, err
+		}
+
+		compiled = append(compiled, re)
+	}
+
+	return compiled, nil
+}
+
+// Helper function to check if a metric name passes the allowlist and denylist.
+func (f metricFilter) matches(name string) bool {
+	if len(f.allow) > 0 && !slices.ContainsFunc(f.allow, func(re *regexp.Regexp) bool { return re.MatchString(name) }) {
+		return false
+	}
+
+	return !slices.ContainsFunc(f.deny, func(re *regexp.Regexp) bool { return re.MatchString(name) })
+}
+
+// Start discovering metrics from annotated pods until the context is cancelled.
+func (p *Provider) Start(ctx context.Context) error {
+	if p.config.Discovery.Interval <= 0 {
+		return nil
+	}
+
+	filter, err := newMetricFilter(p.config.Discovery)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(p.config.Discovery.Interval)
+		defer ticker.Stop()
+
+		for {
+			p.discover(ctx, filter)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Helper function to discover the metric names exported by annotated pods.
+// Metrics are replaced on each discovery, so metrics which are no longer exported stop being advertised.
+func (p *Provider) discover(ctx context.Context, filter metricFilter) {
+	pods, err := p.pods.List(corev1.NamespaceAll, labels.Everything())
+	if err != nil {
+		p.logger.Error("failed to list pods for discovery", "error", err.Error())
+		return
+	}
+
+	pods = slices.DeleteFunc(pods, func(pod *corev1.Pod) bool {
+		discover, _ := strconv.ParseBool(pod.Annotations[AnnotationDiscovery])
+		return !discover || !isRunning(pod)
+	})
+
+	var (
+		lock  sync.Mutex
+		names = map[string]struct{}{}
+	)
+
+	p.forEach(ctx, len(pods), func(ctx context.Context, i int) {
+		result, err := p.getPodFamilies(ctx, pods[i])
+		if err != nil {
+			p.logger.Error("failed to discover metrics for pod", "pod", pods[i].Name, "namespace", pods[i].Namespace, "error", err.Error())
+			return
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		for name, family := range result.families {
+			if isDiscoverable(family) && filter.matches(name) {
+				names[name] = struct{}{}
+			}
+		}
+	})
+
+	var discovered []metricRule
+
+	for name := range names {
+		discovered = append(discovered, metricRule{
+			name:      name,
+			series:    name,
+			resources: append([]schema.GroupResource{PodsResource}, ObjectResources...),
+		})
+	}
+
+	slices.SortFunc(discovered, func(a, b metricRule) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	p.discovered.Store(&discovered)
+
+	discoveredMetrics.Set(float64(len(discovered)))
+}
+
+// Helper function to check if a metric family can be served.
+func isDiscoverable(family *dto.MetricFamily) bool {
+	// Used for checking staleness, not for scaling.
+	if family.GetName() == fpm.MetricLastSuccessfulScrape {
+		return false
+	}
+
+	switch family.GetType() {
+	case dto.MetricType_GAUGE, dto.MetricType_COUNTER, dto.MetricType_UNTYPED, dto.MetricType_HISTOGRAM, dto.MetricType_SUMMARY:
+		return true
+	}
+
+	return false
+}
+
+// Helper function to get the discovered metrics which are not declared by the rules.
+func (p *Provider) getDiscovered() []metricRule {
+	discovered := p.discovered.Load()
+	if discovered == nil {
+		return nil
+	}
+
+	rules := p.rules.Get()
+
+	return slices.DeleteFunc(slices.Clone(*discovered), func(rule metricRule) bool {
+		_, found := rules.get(rule.name)
+		return found
+	})
+}
@@ -0,0 +1,174 @@
+package provider
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/component-base/metrics/testutil"
+	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider"
+
+	"github.com/skpr/fpm-metrics-adapter/internal/fpm"
+)
+
+func TestMetricFilter(t *testing.T) {
+	filter, err := newMetricFilter(DiscoveryConfig{
+		Allow: []string{"php_worker_.*", "", "phpfpm_listen_queue"},
+		Deny:  []string{".*_total"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, expected := range map[string]bool{
+		"php_worker_busy":           true,
+		"php_worker_requests_total": false,
+		// Patterns must match the whole name.
+		"phpfpm_listen_queue_len": false,
+		"phpfpm_listen_queue":     true,
+		"go_goroutines":           false,
+	} {
+		if filter.matches(name) != expected {
+			t.Fatalf("expected %s to match %v", name, expected)
+		}
+	}
+
+	// Empty patterns from unset environment variables allow everything.
+	filter, err = newMetricFilter(DiscoveryConfig{Allow: []string{""}, Deny: []string{""}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !filter.matches("go_goroutines") {
+		t.Fatal("expected every metric to be allowed")
+	}
+
+	if _, err := newMetricFilter(DiscoveryConfig{Deny: []string{"php_worker_(.*"}}); err == nil {
+		t.Fatal("expected an error for an invalid pattern")
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	registerTestMetrics(t)
+
+	discovered := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`
+# TYPE php_worker_busy gauge
+php_worker_busy 4
+# TYPE php_worker_requests_total counter
+php_worker_requests_total 100
+# TYPE php_worker_duration_seconds histogram
+php_worker_duration_seconds_bucket{le="+Inf"} 1
+php_worker_duration_seconds_sum 1
+php_worker_duration_seconds_count 1
+# TYPE phpfpm_listen_queue gauge
+phpfpm_listen_queue 3
+# TYPE phpfpm_last_successful_scrape_timestamp_seconds gauge
+phpfpm_last_successful_scrape_timestamp_seconds 0
+`))
+	}))
+	defer discovered.Close()
+
+	ignored := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("# TYPE php_worker_ignored gauge\nphp_worker_ignored 1\n"))
+	}))
+	defer ignored.Close()
+
+	annotated := getTestServerPod(t, discovered.URL)
+	annotated.Name = "annotated"
+	annotated.Annotations[AnnotationDiscovery] = "true"
+	annotated.Status.Phase = corev1.PodRunning
+
+	unannotated := getTestServerPod(t, ignored.URL)
+	unannotated.Name = "unannotated"
+	unannotated.Status.Phase = corev1.PodRunning
+
+	mapper := apimeta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion})
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Pod"), apimeta.RESTScopeNamespace)
+
+	p := New(slog.New(slog.DiscardHandler), dynamicfake.NewSimpleDynamicClient(scheme.Scheme, annotated, unannotated), startPodCache(t, PodCacheConfig{}, annotated, unannotated), getDefaultRules(t), mapper, Config{
+		CacheExpiration: time.Minute,
+	})
+
+	info := provider.CustomMetricInfo{
+		GroupResource: PodsResource,
+		Metric:        "php_worker_busy",
+		Namespaced:    true,
+	}
+
+	name := types.NamespacedName{Namespace: "default", Name: "annotated"}
+
+	if _, err := p.GetMetricByName(context.Background(), name, info, labels.Everything()); err == nil {
+		t.Fatal("expected an error before the metric is discovered")
+	}
+
+	filter, err := newMetricFilter(DiscoveryConfig{Deny: []string{".*_total"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	p.discover(context.Background(), filter)
+
+	advertised := map[string]int{}
+
+	for _, metric := range p.ListAllMetrics() {
+		advertised[metric.Metric]++
+	}
+
+	resources := 1 + len(ObjectResources)
+
+	for metric, expected := range map[string]int{
+		"php_worker_busy": resources,
+		// Declared by the rules, so not advertised twice.
+		fpm.MetricListenQueue:          resources,
+		"php_worker_requests_total":    0,
+		"php_worker_duration_seconds":  resources,
+		"php_worker_ignored":           0,
+		fpm.MetricLastSuccessfulScrape: 0,
+	} {
+		if advertised[metric] != expected {
+			t.Fatalf("expected %s to be advertised %d times, got %d", metric, expected, advertised[metric])
+		}
+	}
+
+	value, err := p.GetMetricByName(context.Background(), name, info, labels.Everything())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if value.Value.MilliValue() != 4000 {
+		t.Fatalf("expected 4, got %s", value.Value.String())
+	}
+
+	count, err := testutil.GetGaugeMetricValue(discoveredMetrics)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if count != 3 {
+		t.Fatalf("expected 3 discovered metrics, got %v", count)
+	}
+}
+
+func TestStartInvalidPattern(t *testing.T) {
+	p := New(slog.New(slog.DiscardHandler), nil, nil, getDefaultRules(t), nil, Config{
+		Discovery: DiscoveryConfig{
+			Interval: time.Minute,
+			Allow:    []string{"php_worker_(.*"},
+		},
+	})
+
+	if err := p.Start(context.Background()); err == nil {
+		t.Fatal("expected an error for an invalid pattern")
+	}
+}
@@ -0,0 +1,92 @@
+package provider
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/component-base/metrics"
+)
+
+// Namespace for metrics which describe the adapter itself.
+const metricsNamespace = "fpm_metrics_adapter"
+
+var (
+	podCacheSynced = metrics.NewGauge(&metrics.GaugeOpts{
+		Namespace:      metricsNamespace,
+		Name:           "pod_cache_synced",
+		Help:           "Whether the pod cache has synced with the API server.",
+		StabilityLevel: metrics.ALPHA,
+	})
+
+	podCacheSyncDuration = metrics.NewGauge(&metrics.GaugeOpts{
+		Namespace:      metricsNamespace,
+		Name:           "pod_cache_sync_duration_seconds",
+		Help:           "How long the pod cache took to sync with the API server.",
+		StabilityLevel: metrics.ALPHA,
+	})
+
+	podLookupDuration = metrics.NewHistogramVec(&metrics.HistogramOpts{
+		Namespace:      metricsNamespace,
+		Name:           "pod_lookup_duration_seconds",
+		Help:           "Latency of looking up a pod in the pod cache.",
+		StabilityLevel: metrics.ALPHA,
+		Buckets:        metrics.ExponentialBuckets(0.00001, 4, 10),
+	}, []string{"result"})
+
+	selectorFailedPods = metrics.NewHistogram(&metrics.HistogramOpts{
+		Namespace:      metricsNamespace,
+		Name:           "selector_failed_pods",
+		Help:           "Number of running pods which failed to be scraped, for each pod selector or object which metrics were requested for.",
+		StabilityLevel: metrics.ALPHA,
+		Buckets:        []float64{0, 1, 2, 5, 10, 20, 50, 100},
+	})
+
+	rulesReloads = metrics.NewCounterVec(&metrics.CounterOpts{
+		Namespace:      metricsNamespace,
+		Name:           "rules_reloads_total",
+		Help:           "Number of times the rules file was reloaded after it changed.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"result"})
+
+	discoveredMetrics = metrics.NewGauge(&metrics.GaugeOpts{
+		Namespace:      metricsNamespace,
+		Name:           "discovered_metrics",
+		Help:           "Number of metric names discovered from annotated pods.",
+		StabilityLevel: metrics.ALPHA,
+	})
+)
+
+// Duration of the last pod cache sync.
+// Metrics are not recorded until they are registered, so the sync is replayed on registration.
+var podCacheSync atomic.Pointer[time.Duration]
+
+// Helper function to record that the pod cache has synced.
+func setPodCacheSyncMetrics(duration time.Duration) {
+	podCacheSynced.Set(1)
+	podCacheSyncDuration.Set(duration.Seconds())
+}
+
+// RegisterMetrics registers the provider metrics, given a registration function.
+func RegisterMetrics(registrationFunc func(metrics.Registerable) error) error {
+	var errs []error
+
+	for _, metric := range []metrics.Registerable{
+		podCacheSynced,
+		podCacheSyncDuration,
+		podLookupDuration,
+		selectorFailedPods,
+		rulesReloads,
+		discoveredMetrics,
+	} {
+		if err := registrationFunc(metric); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if duration := podCacheSync.Load(); duration != nil {
+		setPodCacheSyncMetrics(*duration)
+	}
+
+	return errors.Join(errs...)
+}
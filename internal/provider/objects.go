@@ -0,0 +1,132 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider"
+	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider/helpers"
+)
+
+// ObjectResources which are resolved to their backing pods, so they can be used with Object metrics.
+var ObjectResources = []schema.GroupResource{
+	{Group: "apps", Resource: "deployments"},
+	{Group: "apps", Resource: "replicasets"},
+	{Group: "apps", Resource: "statefulsets"},
+	{Group: "", Resource: "services"},
+}
+
+// Helper function to get a metric for an object eg. a Deployment, aggregated across the pods which back it.
+// Pods are aggregated by the object's aggregation annotation, otherwise the rule's aggregation, which defaults to sum.
+func (p *Provider) getObjectMetric(ctx context.Context, name types.NamespacedName, info provider.CustomMetricInfo, rule metricRule, metricSelector labels.Selector) (float64, error) {
+	gvr, err := helpers.ResourceFor(p.mapper, info)
+	if err != nil {
+		return 0, err
+	}
+
+	obj, err := p.client.Resource(gvr).Namespace(name.Namespace).Get(ctx, name.Name, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	aggregation, err := getAggregation(obj.GetAnnotations(), info.Metric)
+	if err != nil {
+		return 0, err
+	}
+
+	if aggregation == AggregationNone {
+		aggregation = rule.aggregation
+	}
+
+	if aggregation == AggregationNone {
+		aggregation = AggregationSum
+	}
+
+	selector, err := getPodSelector(obj)
+	if err != nil {
+		return 0, err
+	}
+
+	pods, err := p.pods.List(name.Namespace, selector)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		lock   sync.Mutex
+		values []float64
+	)
+
+	p.forEachPod(ctx, pods, func(ctx context.Context, pod *corev1.Pod) error {
+		metric, err := p.scrape(ctx, pod, rule, metricSelector)
+		if err != nil {
+			return fmt.Errorf("failed to get metrics for %s: %w", name.String(), err)
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		values = append(values, metric)
+
+		return nil
+	})
+
+	if len(values) == 0 {
+		return 0, fmt.Errorf("no running pods returned metrics for %s %s", info.GroupResource.String(), name.String())
+	}
+
+	return aggregation.Apply(values)
+}
+
+// Helper function to get the selector for the pods which back an object.
+// Services use a map of labels, while workloads use a label selector.
+func getPodSelector(obj *unstructured.Unstructured) (labels.Selector, error) {
+	if obj.GetKind() == "Service" {
+		set, _, err := unstructured.NestedStringMap(obj.Object, "spec", "selector")
+		if err != nil {
+			return nil, fmt.Errorf("invalid service selector: %w", err)
+		}
+
+		if len(set) == 0 {
+			return nil, errors.New("service does not have a selector")
+		}
+
+		return labels.SelectorFromSet(set), nil
+	}
+
+	raw, found, err := unstructured.NestedMap(obj.Object, "spec", "selector")
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector: %w", err)
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%s does not have a selector", obj.GetKind())
+	}
+
+	var labelSelector metav1.LabelSelector
+
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &labelSelector); err != nil {
+		return nil, fmt.Errorf("invalid selector: %w", err)
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(&labelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector: %w", err)
+	}
+
+	// An empty selector would match every pod in the namespace.
+	if selector.Empty() {
+		return nil, fmt.Errorf("%s has an empty selector", obj.GetKind())
+	}
+
+	return selector, nil
+}
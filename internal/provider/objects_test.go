@@ -0,0 +1,304 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/component-base/metrics/testutil"
+	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider"
+
+	"github.com/skpr/fpm-metrics-adapter/internal/fpm"
+)
+
+// Helper function to build a running pod which is served by a test server reporting the listen queue.
+func getListenQueuePod(t *testing.T, name string, queue int, podLabels map[string]string) *corev1.Pod {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "# TYPE phpfpm_listen_queue gauge\nphpfpm_listen_queue %d\n", queue)
+	}))
+	t.Cleanup(server.Close)
+
+	pod := getTestServerPod(t, server.URL)
+	pod.Name = name
+	pod.Labels = podLabels
+	pod.Status.Phase = corev1.PodRunning
+
+	return pod
+}
+
+// Helper function to build a provider which can resolve workloads and services.
+func getObjectProvider(t *testing.T, rules *Rules, pods []*corev1.Pod, objects ...runtime.Object) provider.CustomMetricsProvider {
+	registerTestMetrics(t)
+
+	mapper := apimeta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion, appsv1.SchemeGroupVersion})
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Pod"), apimeta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Service"), apimeta.RESTScopeNamespace)
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("Deployment"), apimeta.RESTScopeNamespace)
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("ReplicaSet"), apimeta.RESTScopeNamespace)
+	mapper.Add(appsv1.SchemeGroupVersion.WithKind("StatefulSet"), apimeta.RESTScopeNamespace)
+
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
+
+	return New(logger, dynamicfake.NewSimpleDynamicClient(scheme.Scheme, objects...), startPodCache(t, PodCacheConfig{}, pods...), rules, mapper, Config{
+		CacheExpiration: time.Minute,
+	})
+}
+
+func TestGetObjectMetric(t *testing.T) {
+	drupal := map[string]string{"app": "drupal"}
+
+	pending := testPod("default", "drupal-pending", drupal)
+	pending.Status.Phase = corev1.PodPending
+
+	pods := []*corev1.Pod{
+		getListenQueuePod(t, "drupal-1", 3, drupal),
+		getListenQueuePod(t, "drupal-2", 7, drupal),
+		getListenQueuePod(t, "redis", 100, map[string]string{"app": "redis"}),
+		pending,
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "drupal",
+			Namespace: "default",
+			Annotations: map[string]string{
+				AnnotationAggregation: string(AggregationMax),
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: drupal},
+		},
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "drupal",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: drupal,
+		},
+	}
+
+	p := getObjectProvider(t, getDefaultRules(t), pods, deployment, service)
+
+	before, err := testutil.GetHistogramVecFromGatherer(registerTestMetrics(t), metricsNamespace+"_selector_failed_pods", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, tc := range []struct {
+		resource schema.GroupResource
+		kind     string
+		expected int64
+	}{
+		{schema.GroupResource{Group: "apps", Resource: "deployments"}, "Deployment", 7000},
+		// Services do not have an aggregation annotation, so default to sum.
+		{schema.GroupResource{Resource: "services"}, "Service", 10000},
+	} {
+		info := provider.CustomMetricInfo{
+			GroupResource: tc.resource,
+			Metric:        fpm.MetricListenQueue,
+			Namespaced:    true,
+		}
+
+		value, err := p.GetMetricByName(context.Background(), types.NamespacedName{Namespace: "default", Name: "drupal"}, info, labels.Everything())
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", tc.resource, err)
+		}
+
+		if value.Value.MilliValue() != tc.expected {
+			t.Fatalf("expected %d for %s, got %s", tc.expected, tc.resource, value.Value.String())
+		}
+
+		if value.DescribedObject.Kind != tc.kind || value.DescribedObject.Name != "drupal" {
+			t.Fatalf("expected the described object to be the %s, got %v", tc.kind, value.DescribedObject)
+		}
+	}
+
+	failed, err := testutil.GetHistogramVecFromGatherer(registerTestMetrics(t), metricsNamespace+"_selector_failed_pods", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Observed once for each object, without counting the pending pod as failed.
+	if count := failed.GetAggregatedSampleCount() - before.GetAggregatedSampleCount(); count != 2 {
+		t.Fatalf("expected 2 observations, got %d", count)
+	}
+
+	if sum := failed.GetAggregatedSampleSum() - before.GetAggregatedSampleSum(); sum != 0 {
+		t.Fatalf("expected no failed pods, got %v", sum)
+	}
+}
+
+func TestGetObjectMetricRuleAggregation(t *testing.T) {
+	drupal := map[string]string{"app": "drupal"}
+	wordpress := map[string]string{"app": "wordpress"}
+
+	pods := []*corev1.Pod{
+		getListenQueuePod(t, "drupal-1", 3, drupal),
+		getListenQueuePod(t, "drupal-2", 7, drupal),
+		getListenQueuePod(t, "wordpress-1", 3, wordpress),
+		getListenQueuePod(t, "wordpress-2", 7, wordpress),
+	}
+
+	deployments := []runtime.Object{
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "drupal",
+				Namespace: "default",
+			},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: drupal},
+			},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "wordpress",
+				Namespace: "default",
+				Annotations: map[string]string{
+					AnnotationAggregation: string(AggregationSum),
+				},
+			},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: wordpress},
+			},
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+
+	writeRules(t, path, `
+rules:
+  - name: max_listen_queue
+    series: phpfpm_listen_queue
+    resources: [deployments.apps]
+    aggregation: max
+`)
+
+	rules, err := NewRules(slog.New(slog.DiscardHandler), RulesConfig{Path: path})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	p := getObjectProvider(t, rules, pods, deployments...)
+
+	info := provider.CustomMetricInfo{
+		GroupResource: schema.GroupResource{Group: "apps", Resource: "deployments"},
+		Metric:        "max_listen_queue",
+		Namespaced:    true,
+	}
+
+	for name, expected := range map[string]int64{
+		// The rule's aggregation applies across pods when the deployment is not annotated.
+		"drupal": 7000,
+		// The deployment's annotation takes precedence over the rule.
+		"wordpress": 10000,
+	} {
+		value, err := p.GetMetricByName(context.Background(), types.NamespacedName{Namespace: "default", Name: name}, info, labels.Everything())
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", name, err)
+		}
+
+		if value.Value.MilliValue() != expected {
+			t.Fatalf("expected %d for %s, got %s", expected, name, value.Value.String())
+		}
+	}
+}
+
+func TestGetObjectMetricNoPods(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "drupal",
+			Namespace: "default",
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "drupal"}},
+		},
+	}
+
+	p := getObjectProvider(t, getDefaultRules(t), nil, deployment)
+
+	info := provider.CustomMetricInfo{
+		GroupResource: schema.GroupResource{Group: "apps", Resource: "deployments"},
+		Metric:        fpm.MetricListenQueue,
+		Namespaced:    true,
+	}
+
+	if _, err := p.GetMetricByName(context.Background(), types.NamespacedName{Namespace: "default", Name: "drupal"}, info, labels.Everything()); err == nil {
+		t.Fatal("expected an error when no pods back the deployment")
+	}
+}
+
+func TestGetPodSelector(t *testing.T) {
+	for name, tc := range map[string]struct {
+		object   map[string]any
+		expected string
+	}{
+		"service": {
+			object: map[string]any{
+				"kind": "Service",
+				"spec": map[string]any{"selector": map[string]any{"app": "drupal"}},
+			},
+			expected: "app=drupal",
+		},
+		"statefulset": {
+			object: map[string]any{
+				"kind": "StatefulSet",
+				"spec": map[string]any{"selector": map[string]any{
+					"matchLabels": map[string]any{"app": "drupal"},
+					"matchExpressions": []any{
+						map[string]any{"key": "tier", "operator": "In", "values": []any{"web"}},
+					},
+				}},
+			},
+			expected: "app=drupal,tier in (web)",
+		},
+		"service without selector": {
+			object: map[string]any{"kind": "Service", "spec": map[string]any{}},
+		},
+		"empty selector": {
+			object: map[string]any{
+				"kind": "Deployment",
+				"spec": map[string]any{"selector": map[string]any{}},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			selector, err := getPodSelector(&unstructured.Unstructured{Object: tc.object})
+
+			if tc.expected == "" {
+				if err == nil {
+					t.Fatalf("expected an error, got selector %q", selector)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if selector.String() != tc.expected {
+				t.Fatalf("expected selector %q, got %q", tc.expected, selector)
+			}
+		})
+	}
+}
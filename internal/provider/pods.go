@@ -0,0 +1,94 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// PodCacheConfig for scoping which pods are watched.
+type PodCacheConfig struct {
+	// Namespace to watch pods in. All namespaces are watched when empty.
+	Namespace string
+	// LabelSelector used to filter which pods are watched eg. app=drupal
+	LabelSelector string
+	// Resync period for the informer. Disabled when zero.
+	Resync time.Duration
+}
+
+// PodCache resolves pods from a shared informer, rather than querying the API server for every metric.
+type PodCache struct {
+	factory  informers.SharedInformerFactory
+	informer cache.SharedIndexInformer
+	lister   corelisters.PodLister
+}
+
+// NewPodCache for the pods in scope of the config.
+func NewPodCache(clientset kubernetes.Interface, config PodCacheConfig) (*PodCache, error) {
+	if _, err := labels.Parse(config.LabelSelector); err != nil {
+		return nil, fmt.Errorf("invalid label selector: %w", err)
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, config.Resync,
+		informers.WithNamespace(config.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = config.LabelSelector
+		}),
+	)
+
+	pods := factory.Core().V1().Pods()
+
+	return &PodCache{
+		factory:  factory,
+		informer: pods.Informer(),
+		lister:   pods.Lister(),
+	}, nil
+}
+
+// Start watching pods and wait for the cache to sync.
+func (c *PodCache) Start(ctx context.Context) error {
+	start := time.Now()
+
+	c.factory.Start(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), c.informer.HasSynced) {
+		return errors.New("failed to sync pod cache")
+	}
+
+	duration := time.Since(start)
+
+	podCacheSync.Store(&duration)
+	setPodCacheSyncMetrics(duration)
+
+	return nil
+}
+
+// Get a pod from the cache.
+func (c *PodCache) Get(namespace, name string) (*corev1.Pod, error) {
+	start := time.Now()
+
+	pod, err := c.lister.Pods(namespace).Get(name)
+
+	result := "found"
+	if err != nil {
+		result = "not_found"
+	}
+
+	podLookupDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+
+	return pod, err
+}
+
+// List the pods in a namespace which match a selector.
+func (c *PodCache) List(namespace string, selector labels.Selector) ([]*corev1.Pod, error) {
+	return c.lister.Pods(namespace).List(selector)
+}
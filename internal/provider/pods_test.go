@@ -0,0 +1,159 @@
+package provider
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+var (
+	testRegistry     metrics.KubeRegistry
+	testRegistryOnce sync.Once
+)
+
+// Helper function to register the provider metrics once for all tests.
+func registerTestMetrics(t *testing.T) metrics.KubeRegistry {
+	testRegistryOnce.Do(func() {
+		testRegistry = metrics.NewKubeRegistry()
+
+		if err := RegisterMetrics(testRegistry.Register); err != nil {
+			t.Fatalf("failed to register metrics: %v", err)
+		}
+	})
+
+	return testRegistry
+}
+
+// Helper function to start a pod cache for the test.
+func startPodCache(t *testing.T, config PodCacheConfig, pods ...*corev1.Pod) *PodCache {
+	clientset := fake.NewClientset()
+
+	for _, pod := range pods {
+		if _, err := clientset.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("failed to create pod: %v", err)
+		}
+	}
+
+	cache, err := NewPodCache(clientset, config)
+	if err != nil {
+		t.Fatalf("failed to create pod cache: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	if err := cache.Start(ctx); err != nil {
+		t.Fatalf("failed to start pod cache: %v", err)
+	}
+
+	return cache
+}
+
+func testPod(namespace, name string, labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Status: corev1.PodStatus{
+			PodIP: "127.0.0.1",
+		},
+	}
+}
+
+func TestPodCacheGet(t *testing.T) {
+	registerTestMetrics(t)
+
+	cache := startPodCache(t, PodCacheConfig{}, testPod("default", "test-pod", nil))
+
+	pod, err := cache.Get("default", "test-pod")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if pod.Status.PodIP != "127.0.0.1" {
+		t.Fatalf("expected pod ip 127.0.0.1, got %s", pod.Status.PodIP)
+	}
+
+	_, err = cache.Get("default", "missing-pod")
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	synced, err := testutil.GetGaugeMetricValue(podCacheSynced)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if synced != 1 {
+		t.Fatalf("expected pod cache to be synced, got %v", synced)
+	}
+
+	lookups, err := testutil.GetHistogramMetricCount(podLookupDuration.WithLabelValues("not_found"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if lookups == 0 {
+		t.Fatal("expected lookups to be recorded")
+	}
+}
+
+func TestPodCacheScope(t *testing.T) {
+	config := PodCacheConfig{
+		Namespace:     "drupal",
+		LabelSelector: "app=drupal",
+	}
+
+	cache := startPodCache(t, config,
+		testPod("drupal", "drupal-pod", map[string]string{"app": "drupal"}),
+		testPod("drupal", "redis-pod", map[string]string{"app": "redis"}),
+		testPod("wordpress", "wordpress-pod", map[string]string{"app": "drupal"}),
+	)
+
+	if _, err := cache.Get("drupal", "drupal-pod"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, pod := range []struct{ namespace, name string }{
+		{"drupal", "redis-pod"},
+		{"wordpress", "wordpress-pod"},
+	} {
+		if _, err := cache.Get(pod.namespace, pod.name); !apierrors.IsNotFound(err) {
+			t.Fatalf("expected %s/%s to be out of scope, got %v", pod.namespace, pod.name, err)
+		}
+	}
+}
+
+func TestPodCacheInvalidSelector(t *testing.T) {
+	_, err := NewPodCache(fake.NewClientset(), PodCacheConfig{LabelSelector: "app in (drupal"})
+	if err == nil {
+		t.Fatal("expected an error for an invalid label selector")
+	}
+}
+
+func TestPodCacheStartBeforeRegister(t *testing.T) {
+	// Started before the metrics are registered, like the adapter did previously.
+	startPodCache(t, PodCacheConfig{})
+
+	if err := RegisterMetrics(metrics.NewKubeRegistry().Register); err != nil {
+		t.Fatalf("failed to register metrics: %v", err)
+	}
+
+	synced, err := testutil.GetGaugeMetricValue(podCacheSynced)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if synced != 1 {
+		t.Fatalf("expected the pod cache to be reported as synced, got %v", synced)
+	}
+}
@@ -6,12 +6,20 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/patrickmn/go-cache"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 	"github.com/prometheus/common/model"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 	corev1 "k8s.io/api/core/v1"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -20,8 +28,6 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 	"k8s.io/metrics/pkg/apis/custom_metrics"
 	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider"
 	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider/helpers"
@@ -36,6 +42,10 @@ const (
 	AnnotationPort = "fpm.skpr.io/port"
 	// AnnotationPath is used for configuration which path is used for querying metrics.
 	AnnotationPath = "fpm.skpr.io/path"
+	// AnnotationAggregation is used for configuring how multiple matching series are aggregated.
+	// On an object eg. a Deployment it configures how the values of its pods are aggregated.
+	// It can be set for a single metric by suffixing the metric name eg. fpm.skpr.io/aggregation-phpfpm_listen_queue
+	AnnotationAggregation = "fpm.skpr.io/aggregation"
 
 	// DefaultProtocol used when querying for metrics.
 	DefaultProtocol = "http"
@@ -43,8 +53,44 @@ const (
 	DefaultPort = "80"
 	// DefaultPath used when querying for metrics.
 	DefaultPath = "/metrics"
+
+	// DefaultConcurrency is the maximum number of pods scraped at once when getting metrics by selector.
+	DefaultConcurrency = 10
+	// DefaultQuantile returned for histograms and summaries.
+	DefaultQuantile = 0.95
+	// DefaultPodTimeout for scraping a single pod.
+	DefaultPodTimeout = 5 * time.Second
+	// DefaultRateWindow is how long a scrape is kept for computing the rate of counters.
+	DefaultRateWindow = 5 * time.Minute
 )
 
+// PodsResource which metrics are scraped from.
+var PodsResource = schema.GroupResource{Group: "", Resource: "pods"}
+
+// Metrics which this adapter exposes.
+var Metrics = []string{
+	fpm.MetricListenQueue,
+	fpm.MetricListenQueueLen,
+	fpm.MetricIdleProcesses,
+	fpm.MetricActiveProcesses,
+	fpm.MetricTotalProcesses,
+	fpm.MetricMaxActiveProcesses,
+	fpm.MetricProcessUtilization,
+	fpm.MetricListenQueueUtilization,
+	fpm.MetricListenQueueAvg,
+	fpm.MetricListenQueueP95,
+	fpm.MetricListenQueueMax,
+	fpm.MetricActiveProcessesAvg,
+	fpm.MetricActiveProcessesP95,
+	fpm.MetricActiveProcessesMax,
+	fpm.MetricStartTime,
+	fpm.MetricStartSince,
+	fpm.MetricMaxListenQueue,
+	fpm.MetricAcceptedConnections,
+	fpm.MetricMaxChildrenReached,
+	fpm.MetricSlowRequests,
+}
+
 // CustomMetricResource wraps provider.CustomMetricInfo in a struct which stores the Name and Namespace of the resource
 // So that we can accurately store and retrieve the metric as if this were an actual metrics server.
 type CustomMetricResource struct {
@@ -56,42 +102,95 @@ type CustomMetricResource struct {
 type Provider struct {
 	logger *slog.Logger
 	client dynamic.Interface
-	config *rest.Config
+	pods   *PodCache
+	rules  *Rules
 	mapper apimeta.RESTMapper
-	cache  *cache.Cache
+	// Parsed metric families for each pod, so every metric for a pod is served from a single scrape.
+	cache *cache.Cache
+	// Last scrape of each pod, so counters and histograms can be compared with the previous scrape.
+	history *cache.Cache
+	// Deduplicates concurrent scrapes of the same pod.
+	scrapes singleflight.Group
+	// Metrics discovered from annotated pods.
+	discovered atomic.Pointer[[]metricRule]
+	// Configuration for scraping pods.
+	config Config
+}
+
+// Config for scraping pods.
+type Config struct {
+	// CacheExpiration for the metrics scraped from each pod.
+	CacheExpiration time.Duration
+	// MaxAge of the FPM status reported by a pod before its metrics are refused. Disabled when zero.
+	MaxAge time.Duration
+	// Concurrency is the maximum number of pods scraped at once when getting metrics by selector.
+	Concurrency int
+	// PodTimeout for scraping a single pod. The default is used when zero.
+	PodTimeout time.Duration
+	// SelectorTimeout for scraping all the pods which match a selector. Disabled when zero.
+	SelectorTimeout time.Duration
+	// Discovery of metrics exported by annotated pods.
+	Discovery DiscoveryConfig
+	// Quantile returned for histograms and summaries, unless a rule declares its own.
+	Quantile float64
+	// RateWindow is how long a scrape is kept for computing the rate of counters since the previous scrape.
+	RateWindow time.Duration
 }
 
 // New returns an instance of Provider, along with its restful.WebService that opens endpoints to post new fake metrics
-func New(logger *slog.Logger, client dynamic.Interface, config *rest.Config, mapper apimeta.RESTMapper, cacheExpiration time.Duration) provider.CustomMetricsProvider {
+func New(logger *slog.Logger, client dynamic.Interface, pods *PodCache, rules *Rules, mapper apimeta.RESTMapper, config Config) *Provider {
+	if config.Concurrency <= 0 {
+		config.Concurrency = DefaultConcurrency
+	}
+
+	if config.Quantile <= 0 {
+		config.Quantile = DefaultQuantile
+	}
+
+	if config.RateWindow <= 0 {
+		config.RateWindow = DefaultRateWindow
+	}
+
 	return &Provider{
-		logger: logger,
-		client: client,
-		config: config,
-		mapper: mapper,
-		cache:  cache.New(cacheExpiration, cacheExpiration),
+		logger:  logger,
+		client:  client,
+		pods:    pods,
+		rules:   rules,
+		mapper:  mapper,
+		cache:   cache.New(config.CacheExpiration, config.CacheExpiration),
+		history: cache.New(config.RateWindow, config.RateWindow),
+		config:  config,
 	}
 }
 
 // GetMetricByName returns a single metric by name.
-func (p *Provider) GetMetricByName(ctx context.Context, name types.NamespacedName, info provider.CustomMetricInfo, _ labels.Selector) (*custom_metrics.MetricValue, error) {
+func (p *Provider) GetMetricByName(ctx context.Context, name types.NamespacedName, info provider.CustomMetricInfo, metricSelector labels.Selector) (*custom_metrics.MetricValue, error) {
+	rule, err := p.getRule(info, name.Name)
+	if err != nil {
+		return nil, err
+	}
+
 	ref, err := helpers.ReferenceFor(p.mapper, name, info)
 	if err != nil {
 		return nil, err
 	}
 
-	cacheKey := fmt.Sprintf("%s-%s-%s", ref.Namespace, ref.Name, info.Metric)
+	selector := rule.selectorFor(metricSelector)
+
+	var metric float64
 
-	// Check cache to avoid stampedes.
-	if cached, found := p.cache.Get(cacheKey); found {
-		return cached.(*custom_metrics.MetricValue), nil
+	if info.GroupResource == PodsResource {
+		metric, err = p.getPodMetric(ctx, name, rule, selector)
+	} else {
+		// Objects eg. Deployments are resolved to the pods which back them.
+		metric, err = p.getObjectMetric(ctx, name, info, rule, selector)
 	}
 
-	clientset, err := kubernetes.NewForConfig(p.config)
 	if err != nil {
 		return nil, err
 	}
 
-	metric, err := scrape(ctx, clientset, ref.Namespace, ref.Name, info.Metric)
+	quantity, err := newQuantity(metric)
 	if err != nil {
 		return nil, err
 	}
@@ -102,40 +201,66 @@ func (p *Provider) GetMetricByName(ctx context.Context, name types.NamespacedNam
 			Name: info.Metric,
 		},
 		Timestamp: metav1.Time{Time: time.Now()},
-		Value:     *resource.NewQuantity(metric, resource.DecimalExponent),
+		Value:     quantity,
 	}
 
-	// Store this in the cache to avoid stampedes.
-	p.cache.Set(cacheKey, value, cache.DefaultExpiration)
-
 	return value, nil
 }
 
 // GetMetricBySelector returns a set of metrics queried by selector.
 // https://github.com/kubernetes-incubator/custom-metrics-apiserver/blob/master/test-adapter/provider/provider.go#L234
 func (p *Provider) GetMetricBySelector(ctx context.Context, namespace string, selector labels.Selector, info provider.CustomMetricInfo, metricSelector labels.Selector) (*custom_metrics.MetricValueList, error) {
-	names, err := helpers.ListObjectNames(p.mapper, p.client, namespace, selector, info)
-	if err != nil {
+	if _, err := p.getRule(info, selector.String()); err != nil {
 		return nil, err
 	}
 
-	var items []custom_metrics.MetricValue
+	var (
+		lock  sync.Mutex
+		items []custom_metrics.MetricValue
+	)
 
-	for _, name := range names {
-		n := types.NamespacedName{
-			Name:      name,
-			Namespace: namespace,
+	// Return partial results for the pods or objects which answered.
+	getMetric := func(ctx context.Context, name types.NamespacedName) error {
+		metric, err := p.GetMetricByName(ctx, name, info, metricSelector)
+		if err != nil {
+			return err
 		}
 
-		metric, err := p.GetMetricByName(ctx, n, info, metricSelector)
+		lock.Lock()
+		defer lock.Unlock()
+
+		items = append(items, *metric)
+
+		return nil
+	}
+
+	if info.GroupResource == PodsResource {
+		// Listed from the pod cache, so they are bounded by the pod namespace and selector without a request to the API server.
+		pods, err := p.pods.List(namespace, selector)
 		if err != nil {
-			p.logger.Error("failed to get metrics by name", "error", err.Error())
-			continue
+			return nil, fmt.Errorf("failed to list pods: %w", err)
 		}
 
-		items = append(items, *metric)
+		p.forEachPod(ctx, pods, func(ctx context.Context, pod *corev1.Pod) error {
+			return getMetric(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name})
+		})
+	} else {
+		names, err := helpers.ListObjectNames(p.mapper, p.client, namespace, selector, info)
+		if err != nil {
+			return nil, err
+		}
+
+		p.forEach(ctx, len(names), func(ctx context.Context, i int) {
+			if err := getMetric(ctx, types.NamespacedName{Namespace: namespace, Name: names[i]}); err != nil {
+				p.logger.Error("failed to get metrics by name", "name", names[i], "error", err.Error())
+			}
+		})
 	}
 
+	slices.SortFunc(items, func(a, b custom_metrics.MetricValue) int {
+		return strings.Compare(a.DescribedObject.Name, b.DescribedObject.Name)
+	})
+
 	list := &custom_metrics.MetricValueList{
 		Items: items,
 	}
@@ -145,92 +270,351 @@ func (p *Provider) GetMetricBySelector(ctx context.Context, namespace string, se
 
 // ListAllMetrics which this adapter exposes.
 func (p *Provider) ListAllMetrics() []provider.CustomMetricInfo {
-	return []provider.CustomMetricInfo{
-		{
-			GroupResource: schema.GroupResource{Group: "", Resource: "pods"},
-			Metric:        fpm.MetricListenQueue,
-			Namespaced:    true,
-		},
-		{
-			GroupResource: schema.GroupResource{Group: "", Resource: "pods"},
-			Metric:        fpm.MetricListenQueueLen,
-			Namespaced:    true,
-		},
-		{
-			GroupResource: schema.GroupResource{Group: "", Resource: "pods"},
-			Metric:        fpm.MetricIdleProcesses,
-			Namespaced:    true,
-		},
-		{
-			GroupResource: schema.GroupResource{Group: "", Resource: "pods"},
-			Metric:        fpm.MetricActiveProcesses,
-			Namespaced:    true,
-		},
-		{
-			GroupResource: schema.GroupResource{Group: "", Resource: "pods"},
-			Metric:        fpm.MetricTotalProcesses,
-			Namespaced:    true,
-		},
-		{
-			GroupResource: schema.GroupResource{Group: "", Resource: "pods"},
-			Metric:        fpm.MetricMaxActiveProcesses,
-			Namespaced:    true,
-		},
+	return append(p.rules.Get().List(), listMetrics(p.getDiscovered())...)
+}
+
+// Helper function to get the rule for a metric, if it is exposed for the resource.
+// Metrics which are not declared by the rules fall back to the discovered metrics.
+func (p *Provider) getRule(info provider.CustomMetricInfo, name string) (metricRule, error) {
+	rule, found := p.rules.Get().get(info.Metric)
+	if !found {
+		discovered := p.getDiscovered()
+
+		if i := slices.IndexFunc(discovered, func(rule metricRule) bool { return rule.name == info.Metric }); i >= 0 {
+			rule, found = discovered[i], true
+		}
 	}
+
+	if !found || !rule.supports(info.GroupResource) {
+		return metricRule{}, provider.NewMetricNotFoundForError(info.GroupResource, info.Metric, name)
+	}
+
+	return rule, nil
 }
 
-// Scrape the context of the PHP-FPM exporter.
-func scrape(ctx context.Context, clientset kubernetes.Interface, namespace, name, metric string) (int64, error) {
-	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+// Helper function to call the given function for each item concurrently, so one slow pod does not stall the whole request.
+// Concurrency is bounded and the selector timeout applies to all items. Items which have not started by the timeout are skipped.
+func (p *Provider) forEach(ctx context.Context, count int, fn func(ctx context.Context, i int)) {
+	if p.config.SelectorTimeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, p.config.SelectorTimeout)
+		defer cancel()
+	}
+
+	var group errgroup.Group
+
+	group.SetLimit(p.config.Concurrency)
+
+	for i := range count {
+		// Stop launching work once the timeout has passed, since each item would only start a scrape which is abandoned.
+		if ctx.Err() != nil {
+			break
+		}
+
+		group.Go(func() error {
+			// Waiting for a slot may have outlasted the timeout.
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			fn(ctx, i)
+			return nil
+		})
+	}
+
+	_ = group.Wait()
+}
+
+// Helper function to call the given function for each running pod concurrently.
+// Pods which are not running yet are skipped, rather than counted as failures.
+func (p *Provider) forEachPod(ctx context.Context, pods []*corev1.Pod, fn func(ctx context.Context, pod *corev1.Pod) error) {
+	pods = slices.DeleteFunc(slices.Clone(pods), func(pod *corev1.Pod) bool {
+		return !isRunning(pod)
+	})
+
+	var succeeded atomic.Int64
+
+	p.forEach(ctx, len(pods), func(ctx context.Context, i int) {
+		if err := fn(ctx, pods[i]); err != nil {
+			p.logger.Error("failed to get metrics for pod", "pod", pods[i].Name, "namespace", pods[i].Namespace, "error", err.Error())
+			return
+		}
+
+		succeeded.Add(1)
+	})
+
+	// Pods which were skipped at the selector timeout are also failures.
+	selectorFailedPods.Observe(float64(len(pods) - int(succeeded.Load())))
+}
+
+// Helper function to check if a pod is running and can be scraped.
+func isRunning(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodRunning && pod.Status.PodIP != ""
+}
+
+// Helper function to get a metric for a pod.
+func (p *Provider) getPodMetric(ctx context.Context, name types.NamespacedName, rule metricRule, selector labels.Selector) (float64, error) {
+	pod, err := p.pods.Get(name.Namespace, name.Name)
 	if err != nil {
 		return 0, err
 	}
 
-	endpoint, err := getConn(pod)
+	return p.scrape(ctx, pod, rule, selector)
+}
+
+// Scrape the series for a rule from the PHP-FPM exporter of a pod.
+// The aggregation annotations of the pod take precedence over the aggregation of the rule.
+func (p *Provider) scrape(ctx context.Context, pod *corev1.Pod, rule metricRule, selector labels.Selector) (float64, error) {
+	aggregation, err := getAggregation(pod.Annotations, rule.name)
 	if err != nil {
 		return 0, err
 	}
 
-	resp, err := getMetric(endpoint, metric)
+	if aggregation == AggregationNone {
+		aggregation = rule.aggregation
+	}
+
+	quantile := rule.quantile
+	if quantile == 0 {
+		quantile = p.config.Quantile
+	}
+
+	result, err := p.getPodFamilies(ctx, pod)
 	if err != nil {
 		return 0, err
 	}
 
-	return resp, nil
+	return selectMetric(result, rule.series, selector, aggregation, quantile, p.config.MaxAge)
 }
 
-func getMetric(endpoint string, metric string) (int64, error) {
-	resp, err := http.Get(endpoint)
+// Helper function to get the metric families for a pod.
+func (p *Provider) getPodFamilies(ctx context.Context, pod *corev1.Pod) (*scrapeResult, error) {
+	endpoint, err := getConn(pod)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	return p.getFamilies(ctx, endpoint)
+}
+
+// Helper function to get the metric families for an endpoint.
+// Each endpoint is scraped at most once per cache expiration, with concurrent scrapes deduplicated.
+// The shared scrape is not cancelled by any single caller, while each caller stops waiting when its context is done.
+func (p *Provider) getFamilies(ctx context.Context, endpoint string) (*scrapeResult, error) {
+	if cached, found := p.cache.Get(endpoint); found {
+		return cached.(*scrapeResult), nil
+	}
+
+	// Avoid starting a scrape which the caller has already given up on.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Detached from the first caller, so its deadline does not fail the other callers.
+	scrapeCtx := context.WithoutCancel(ctx)
+
+	ch := p.scrapes.DoChan(endpoint, func() (any, error) {
+		// Always bounded, otherwise a pod which never responds would block every later scrape of it.
+		ctx, cancel := context.WithTimeout(scrapeCtx, p.podTimeout())
+		defer cancel()
+
+		families, err := fetchFamilies(ctx, endpoint)
+		if err != nil {
+			return nil, err
+		}
+
+		result := &scrapeResult{
+			families:  families,
+			timestamp: time.Now(),
+		}
+
+		// Only keep a single previous scrape, rather than the whole history.
+		if previous, found := p.history.Get(endpoint); found {
+			result.previous = &scrapeResult{
+				families:  previous.(*scrapeResult).families,
+				timestamp: previous.(*scrapeResult).timestamp,
+			}
+		}
+
+		p.history.Set(endpoint, result, cache.DefaultExpiration)
+		p.cache.Set(endpoint, result, cache.DefaultExpiration)
+
+		return result, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+
+		return res.Val.(*scrapeResult), nil
+	}
+}
+
+// Helper function to get the timeout for scraping a single pod.
+func (p *Provider) podTimeout() time.Duration {
+	if p.config.PodTimeout <= 0 {
+		return DefaultPodTimeout
+	}
+
+	return p.config.PodTimeout
+}
+
+// Helper function to fetch and parse the metric families from an endpoint.
+func fetchFamilies(ctx context.Context, endpoint string) (map[string]*dto.MetricFamily, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
 	}
 
 	defer func() {
 		err = resp.Body.Close()
 	}()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
 	parser := expfmt.NewTextParser(model.UTF8Validation)
-	metrics, err := parser.TextToMetricFamilies(resp.Body)
+
+	return parser.TextToMetricFamilies(resp.Body)
+}
+
+// Helper function to get the value of the series which match the selector eg. pool=web
+// Metrics are refused if the sidecar reports an FPM status older than the max age.
+// Counters are converted to a per-second rate and histograms and summaries return the quantile.
+// NaN and infinite values are refused, rather than being served.
+func selectMetric(result *scrapeResult, metric string, selector labels.Selector, aggregation Aggregation, quantile float64, maxAge time.Duration) (float64, error) {
+	if err := checkStaleness(result.families, maxAge); err != nil {
+		return 0, err
+	}
+
+	value, err := selectValue(result, metric, selector, aggregation, quantile)
 	if err != nil {
 		return 0, err
 	}
 
-	m, ok := metrics[metric]
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("metric is not a finite number: %v", value)
+	}
+
+	return value, nil
+}
+
+// Helper function to get the value of the series which match the selector, aggregated or as a quantile.
+func selectValue(result *scrapeResult, metric string, selector labels.Selector, aggregation Aggregation, quantile float64) (float64, error) {
+	m, ok := result.families[metric]
 	if !ok {
 		return 0, errors.New("not found")
 	}
 
-	value := m.GetMetric()
+	var matched []*dto.Metric
+
+	for _, series := range m.GetMetric() {
+		if selector.Matches(seriesLabels(series)) {
+			matched = append(matched, series)
+		}
+	}
+
+	if len(matched) == 0 {
+		return 0, fmt.Errorf("no metrics found matching selector: %q", selector.String())
+	}
+
+	// Histogram buckets are summed across series, so the aggregation does not apply.
+	if m.GetType() == dto.MetricType_HISTOGRAM {
+		return result.histogramQuantile(metric, matched, quantile)
+	}
+
+	var values []float64
+
+	for _, series := range matched {
+		value, err := result.seriesValue(metric, series, quantile)
+		if err != nil {
+			return 0, err
+		}
+
+		values = append(values, value)
+	}
+
+	if aggregation == AggregationNone && len(values) > 1 {
+		return 0, fmt.Errorf("found %d metrics matching selector, expected 1: %q", len(values), selector.String())
+	}
+
+	return aggregation.Apply(values)
+}
+
+// Helper function to convert a metric to a quantity.
+// Milli-quantities preserve ratios eg. 0.7 utilisation is reported as 700m.
+// Values which would overflow as milli-quantities are scaled down, keeping the precision of a float64.
+func newQuantity(value float64) (resource.Quantity, error) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return resource.Quantity{}, fmt.Errorf("metric is not a finite number: %v", value)
+	}
+
+	if milli := value * 1000; math.Abs(milli) < math.MaxInt64 {
+		return *resource.NewMilliQuantity(int64(math.Round(milli)), resource.DecimalSI), nil
+	}
+
+	// A float64 has 15 to 17 significant digits, so keep 15 of them.
+	scale := int(math.Floor(math.Log10(math.Abs(value)))) - 14
+
+	// The exponent format is used, because SI suffixes do not go beyond E.
+	quantity, err := resource.ParseQuantity(fmt.Sprintf("%de%d", int64(math.Round(value/math.Pow10(scale))), scale))
+	if err != nil {
+		return resource.Quantity{}, err
+	}
+
+	// Consumers can only approximate quantities as a float64 up to a limit, so make sure the value survives a round trip.
+	parsed, err := resource.ParseQuantity(quantity.String())
+	if err != nil || math.Abs(parsed.AsApproximateFloat64()-value) > math.Abs(value)*1e-12 {
+		return resource.Quantity{}, fmt.Errorf("metric is too large to be represented as a quantity: %v", value)
+	}
+
+	return quantity, nil
+}
+
+// Helper function to check when the sidecar last successfully queried FPM.
+func checkStaleness(metrics map[string]*dto.MetricFamily, maxAge time.Duration) error {
+	if maxAge <= 0 {
+		return nil
+	}
+
+	m, ok := metrics[fpm.MetricLastSuccessfulScrape]
+	if !ok {
+		return nil
+	}
+
+	for _, series := range m.GetMetric() {
+		lastSuccess := time.Unix(int64(series.GetGauge().GetValue()), 0)
+
+		if time.Since(lastSuccess) > maxAge {
+			return fmt.Errorf("stale metrics: last successful query of fpm status was at %s", lastSuccess.Format(time.RFC3339))
+		}
+	}
+
+	return nil
+}
 
-	if len(value) == 0 {
-		return 0, errors.New("no metrics found")
+// Helper function to get the aggregation for a metric from the annotations of a Pod or object.
+func getAggregation(annotations map[string]string, metric string) (Aggregation, error) {
+	if val, ok := annotations[fmt.Sprintf("%s-%s", AnnotationAggregation, metric)]; ok {
+		return ParseAggregation(val)
 	}
 
-	if value[0].GetGauge() == nil {
-		return 0, errors.New("metric is not a gauge")
+	if val, ok := annotations[AnnotationAggregation]; ok {
+		return ParseAggregation(val)
 	}
 
-	return int64(value[0].GetGauge().GetValue()), nil
+	return AggregationNone, nil
 }
 
 // Helper function to get connection details from a Pod.
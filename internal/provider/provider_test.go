@@ -2,14 +2,34 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/patrickmn/go-cache"
 	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/component-base/metrics/testutil"
+	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider"
 
 	"github.com/skpr/fpm-metrics-adapter/internal/fpm"
 )
@@ -49,6 +69,61 @@ func getClientset() *fake.Clientset {
 	return fakeClientset
 }
 
+// Helper function to fetch the metric families from an endpoint and select a metric.
+func getMetric(endpoint string, metric string, selector labels.Selector, aggregation Aggregation, maxAge time.Duration) (float64, error) {
+	families, err := fetchFamilies(context.Background(), endpoint)
+	if err != nil {
+		return 0, err
+	}
+
+	return selectMetric(&scrapeResult{families: families, timestamp: time.Now()}, metric, selector, aggregation, DefaultQuantile, maxAge)
+}
+
+func TestGetAggregation(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				AnnotationAggregation:                               "sum",
+				AnnotationAggregation + "-" + fpm.MetricListenQueue: "max",
+			},
+		},
+	}
+
+	aggregation, err := getAggregation(pod.Annotations, fpm.MetricListenQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if aggregation != AggregationMax {
+		t.Fatalf("expected metric aggregation %q, got %q", AggregationMax, aggregation)
+	}
+
+	aggregation, err = getAggregation(pod.Annotations, fpm.MetricActiveProcesses)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if aggregation != AggregationSum {
+		t.Fatalf("expected pod aggregation %q, got %q", AggregationSum, aggregation)
+	}
+
+	aggregation, err = getAggregation(nil, fpm.MetricActiveProcesses)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if aggregation != AggregationNone {
+		t.Fatalf("expected no aggregation, got %q", aggregation)
+	}
+
+	pod.Annotations[AnnotationAggregation] = "median"
+
+	_, err = getAggregation(pod.Annotations, fpm.MetricActiveProcesses)
+	if err == nil {
+		t.Fatalf("expected an error: %v", err)
+	}
+}
+
 func TestGetConnFailIp(t *testing.T) {
 	clientset := getClientset()
 
@@ -91,6 +166,12 @@ func TestGetMetric(t *testing.T) {
 # HELP phpfpm_idle_processes The number of idle fpm processes.
 # TYPE phpfpm_idle_processes gauge
 phpfpm_idle_processes 101
+# HELP phpfpm_slow_requests_total The total number of requests which exceeded the fpm request_slowlog_timeout.
+# TYPE phpfpm_slow_requests_total counter
+phpfpm_slow_requests_total 7
+# HELP phpfpm_pool_info The name and process manager type of the fpm pool.
+# TYPE phpfpm_pool_info untyped
+phpfpm_pool_info{pool="www",process_manager="dynamic"} 1
 `
 
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -106,19 +187,653 @@ phpfpm_idle_processes 101
 	}))
 
 	endpoint := fmt.Sprintf("%s/metrics", mockServer.URL)
-	resp, err := getMetric(endpoint, fpm.MetricIdleProcesses)
+	resp, err := getMetric(endpoint, fpm.MetricIdleProcesses, labels.Everything(), AggregationNone, 0)
 
 	if err != nil {
 		t.Fatalf("unable to scrape metrics: %v", err)
 	}
 
 	if resp != 101 {
-		t.Fatalf("metrics scrape did not return 101. got %d", resp)
+		t.Fatalf("metrics scrape did not return 101. got %v", resp)
+	}
+
+	// Counters are a rate, which requires a previous scrape.
+	_, err = getMetric(endpoint, fpm.MetricSlowRequests, labels.Everything(), AggregationNone, 0)
+	if err == nil || !strings.Contains(err.Error(), "previous scrape") {
+		t.Fatalf("expected a previous scrape error: %v", err)
+	}
+
+	resp, err = getMetric(endpoint, fpm.MetricPoolInfo, labels.Everything(), AggregationNone, 0)
+	if err != nil {
+		t.Fatalf("unable to scrape metrics: %v", err)
+	}
+
+	if resp != 1 {
+		t.Fatalf("metrics scrape did not return 1. got %v", resp)
 	}
 
 	// Make sure we're handling unknown.
-	_, err = getMetric(endpoint, "phpfpm_unknown_metric")
+	_, err = getMetric(endpoint, "phpfpm_unknown_metric", labels.Everything(), AggregationNone, 0)
 	if err == nil {
 		t.Fatalf("expected an error: %v", err)
 	}
 }
+
+func TestGetMetricPool(t *testing.T) {
+	prom := `
+# HELP phpfpm_listen_queue The number of items in the listen queue.
+# TYPE phpfpm_listen_queue gauge
+phpfpm_listen_queue{pool="web"} 12
+phpfpm_listen_queue{pool="admin"} 3
+`
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(prom))
+		if err != nil {
+			t.Fatalf("unable to write response: %v", err)
+		}
+	}))
+
+	selector, err := labels.Parse("pool=admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := getMetric(mockServer.URL, fpm.MetricListenQueue, selector, AggregationNone, 0)
+	if err != nil {
+		t.Fatalf("unable to scrape metrics: %v", err)
+	}
+
+	if resp != 3 {
+		t.Fatalf("metrics scrape did not return 3. got %v", resp)
+	}
+
+	selector, err = labels.Parse("pool=cron")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Make sure we're handling pools which don't exist.
+	_, err = getMetric(mockServer.URL, fpm.MetricListenQueue, selector, AggregationNone, 0)
+	if err == nil {
+		t.Fatalf("expected an error: %v", err)
+	}
+}
+
+func TestGetMetricSelector(t *testing.T) {
+	prom := `
+# HELP phpfpm_processes_by_state The number of fpm processes in each state.
+# TYPE phpfpm_processes_by_state gauge
+phpfpm_processes_by_state{pool="web",state="Idle"} 4
+phpfpm_processes_by_state{pool="web",state="Running"} 6
+phpfpm_processes_by_state{pool="admin",state="Running"} 1
+`
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(prom))
+		if err != nil {
+			t.Fatalf("unable to write response: %v", err)
+		}
+	}))
+
+	tests := []struct {
+		selector    string
+		aggregation Aggregation
+		expected    float64
+		err         string
+	}{
+		{selector: "pool=web,state=Running", expected: 6},
+		{selector: "pool=admin", expected: 1},
+		{selector: "state!=Running", expected: 4},
+		{selector: "pool=web", err: "found 2 metrics matching selector"},
+		{selector: "", err: "found 3 metrics matching selector"},
+		{selector: "pool=cron", err: "no metrics found matching selector"},
+		{selector: "pool=web", aggregation: AggregationSum, expected: 10},
+		{selector: "", aggregation: AggregationSum, expected: 11},
+		{selector: "", aggregation: AggregationMax, expected: 6},
+		{selector: "", aggregation: AggregationMin, expected: 1},
+		{selector: "pool=web", aggregation: AggregationAvg, expected: 5},
+		{selector: "state=Running", aggregation: AggregationAvg, expected: 3.5},
+		{selector: "pool=cron", aggregation: AggregationSum, err: "no metrics found matching selector"},
+	}
+
+	for _, tc := range tests {
+		selector, err := labels.Parse(tc.selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := getMetric(mockServer.URL, fpm.MetricProcessesByState, selector, tc.aggregation, 0)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing %q for selector %q, got: %v", tc.err, tc.selector, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("unable to scrape metrics for selector %q: %v", tc.selector, err)
+		}
+
+		if resp != tc.expected {
+			t.Fatalf("metrics scrape for selector %q did not return %v. got %v", tc.selector, tc.expected, resp)
+		}
+	}
+}
+
+func TestGetMetricStale(t *testing.T) {
+	lastSuccess := time.Now()
+	statusCode := http.StatusOK
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prom := fmt.Sprintf(`
+# HELP phpfpm_last_successful_scrape_timestamp_seconds The unix timestamp of the last successful query of the fpm status.
+# TYPE phpfpm_last_successful_scrape_timestamp_seconds gauge
+phpfpm_last_successful_scrape_timestamp_seconds{pool="www"} %d
+# HELP phpfpm_listen_queue The number of items in the listen queue.
+# TYPE phpfpm_listen_queue gauge
+phpfpm_listen_queue{pool="www"} 5
+`, lastSuccess.Unix())
+
+		w.WriteHeader(statusCode)
+		_, err := w.Write([]byte(prom))
+		if err != nil {
+			t.Fatalf("unable to write response: %v", err)
+		}
+	}))
+
+	resp, err := getMetric(mockServer.URL, fpm.MetricListenQueue, labels.Everything(), AggregationNone, time.Minute)
+	if err != nil {
+		t.Fatalf("unable to scrape metrics: %v", err)
+	}
+
+	if resp != 5 {
+		t.Fatalf("metrics scrape did not return 5. got %v", resp)
+	}
+
+	lastSuccess = time.Now().Add(-5 * time.Minute)
+
+	_, err = getMetric(mockServer.URL, fpm.MetricListenQueue, labels.Everything(), AggregationNone, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "stale metrics") {
+		t.Fatalf("expected a stale metrics error: %v", err)
+	}
+
+	// Staleness checks can be disabled.
+	_, err = getMetric(mockServer.URL, fpm.MetricListenQueue, labels.Everything(), AggregationNone, 0)
+	if err != nil {
+		t.Fatalf("unable to scrape metrics: %v", err)
+	}
+
+	statusCode = http.StatusServiceUnavailable
+
+	_, err = getMetric(mockServer.URL, fpm.MetricListenQueue, labels.Everything(), AggregationNone, 0)
+	if err == nil || !strings.Contains(err.Error(), "unexpected status code: 503") {
+		t.Fatalf("expected a status code error: %v", err)
+	}
+}
+
+func TestScrapeOncePerPod(t *testing.T) {
+	prom := `
+# HELP phpfpm_listen_queue The number of items in the listen queue.
+# TYPE phpfpm_listen_queue gauge
+phpfpm_listen_queue 3
+# HELP phpfpm_active_processes The number of active fpm processes.
+# TYPE phpfpm_active_processes gauge
+phpfpm_active_processes 5
+# HELP phpfpm_process_utilization The ratio of active fpm processes to pm.max_children.
+# TYPE phpfpm_process_utilization gauge
+phpfpm_process_utilization 0.5
+`
+
+	var (
+		requests atomic.Int64
+		release  = make(chan struct{})
+	)
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		// Hold the scrape open so concurrent requests overlap.
+		<-release
+		_, _ = w.Write([]byte(prom))
+	}))
+	defer mockServer.Close()
+
+	pod := getTestServerPod(t, mockServer.URL)
+
+	p := &Provider{
+		cache:   cache.New(time.Minute, time.Minute),
+		history: cache.New(time.Minute, time.Minute),
+	}
+
+	var wg sync.WaitGroup
+
+	for _, metric := range []string{fpm.MetricListenQueue, fpm.MetricActiveProcesses, fpm.MetricProcessUtilization} {
+		for i := 0; i < 3; i++ {
+			wg.Go(func() {
+				if _, err := p.scrape(context.Background(), pod, testRule(metric), labels.Everything()); err != nil {
+					t.Errorf("unable to scrape metrics: %v", err)
+				}
+			})
+		}
+	}
+
+	// Give the scrapes a moment to start before releasing the response.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// Served from the cache.
+	value, err := p.scrape(context.Background(), pod, testRule(fpm.MetricActiveProcesses), labels.Everything())
+	if err != nil {
+		t.Fatalf("unable to scrape metrics: %v", err)
+	}
+
+	if value != 5 {
+		t.Fatalf("expected 5, got %v", value)
+	}
+
+	if requests.Load() != 1 {
+		t.Fatalf("expected the pod to be scraped once, got %d", requests.Load())
+	}
+}
+
+func TestScrapeErrorNotCached(t *testing.T) {
+	var requests atomic.Int64
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer mockServer.Close()
+
+	pod := getTestServerPod(t, mockServer.URL)
+
+	p := &Provider{
+		cache:   cache.New(time.Minute, time.Minute),
+		history: cache.New(time.Minute, time.Minute),
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.scrape(context.Background(), pod, testRule(fpm.MetricListenQueue), labels.Everything()); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+
+	if requests.Load() != 2 {
+		t.Fatalf("expected failed scrapes to be retried, got %d requests", requests.Load())
+	}
+}
+
+func TestScrapeCallerContext(t *testing.T) {
+	release := make(chan struct{})
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		_, _ = w.Write([]byte("# TYPE phpfpm_listen_queue gauge\nphpfpm_listen_queue 3\n"))
+	}))
+	defer mockServer.Close()
+
+	pod := getTestServerPod(t, mockServer.URL)
+
+	p := &Provider{
+		cache:   cache.New(time.Minute, time.Minute),
+		history: cache.New(time.Minute, time.Minute),
+	}
+
+	// The first caller starts the scrape and gives up waiting for it.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	errs := make(chan error, 1)
+
+	go func() {
+		_, err := p.scrape(ctx, pod, testRule(fpm.MetricListenQueue), labels.Everything())
+		errs <- err
+	}()
+
+	// Give the first caller a moment to start the scrape.
+	time.Sleep(10 * time.Millisecond)
+
+	values := make(chan float64, 1)
+
+	go func() {
+		value, err := p.scrape(context.Background(), pod, testRule(fpm.MetricListenQueue), labels.Everything())
+		if err != nil {
+			t.Errorf("expected the deadline of another caller to be ignored: %v", err)
+		}
+		values <- value
+	}()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected the caller to stop waiting at its deadline, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the caller to stop waiting at its deadline")
+	}
+
+	close(release)
+
+	if value := <-values; value != 3 {
+		t.Fatalf("expected 3, got %v", value)
+	}
+}
+
+func TestScrapeTimeout(t *testing.T) {
+	// A timeout is always applied, so a pod which never responds cannot block later scrapes.
+	if timeout := (&Provider{}).podTimeout(); timeout != DefaultPodTimeout {
+		t.Fatalf("expected the default timeout, got %s", timeout)
+	}
+
+	var hung atomic.Bool
+
+	hung.Store(true)
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hung.Load() {
+			<-r.Context().Done()
+			return
+		}
+
+		_, _ = w.Write([]byte("# TYPE phpfpm_listen_queue gauge\nphpfpm_listen_queue 3\n"))
+	}))
+	defer mockServer.Close()
+
+	pod := getTestServerPod(t, mockServer.URL)
+
+	p := &Provider{
+		cache:   cache.New(time.Minute, time.Minute),
+		history: cache.New(time.Minute, time.Minute),
+		config: Config{
+			PodTimeout: 50 * time.Millisecond,
+		},
+	}
+
+	if _, err := p.scrape(context.Background(), pod, testRule(fpm.MetricListenQueue), labels.Everything()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the scrape to time out, got %v", err)
+	}
+
+	// The pod has recovered.
+	hung.Store(false)
+
+	value, err := p.scrape(context.Background(), pod, testRule(fpm.MetricListenQueue), labels.Everything())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if value != 3 {
+		t.Fatalf("expected 3, got %v", value)
+	}
+}
+
+func TestForEachSelectorTimeout(t *testing.T) {
+	p := &Provider{
+		config: Config{
+			Concurrency:     1,
+			SelectorTimeout: 20 * time.Millisecond,
+		},
+	}
+
+	var calls atomic.Int64
+
+	p.forEach(context.Background(), 10, func(ctx context.Context, i int) {
+		calls.Add(1)
+		<-ctx.Done()
+	})
+
+	// Only the first item started before the timeout.
+	if calls.Load() != 1 {
+		t.Fatalf("expected no items to start after the timeout, got %d calls", calls.Load())
+	}
+}
+
+// Helper function to build a pod which is served by a test server.
+func getTestServerPod(t *testing.T, serverURL string) *corev1.Pod {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		t.Fatalf("unable to parse url: %v", err)
+	}
+
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "default",
+			Annotations: map[string]string{
+				AnnotationPort: u.Port(),
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			PodIP: u.Hostname(),
+		},
+	}
+}
+
+func TestGetMetricBySelector(t *testing.T) {
+	registry := registerTestMetrics(t)
+
+	prom := `
+# HELP phpfpm_listen_queue The number of items in the listen queue.
+# TYPE phpfpm_listen_queue gauge
+phpfpm_listen_queue 3
+`
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(prom))
+	}))
+	defer healthy.Close()
+
+	// Responds after the per-pod timeout.
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	var pods []*corev1.Pod
+
+	for name, server := range map[string]*httptest.Server{
+		"healthy-1": healthy,
+		"healthy-2": healthy,
+		"slow":      slow,
+		"failing":   failing,
+	} {
+		pod := getTestServerPod(t, server.URL)
+		pod.Name = name
+		// Distinguish the pods which share a server.
+		pod.Annotations[AnnotationPath] = "/metrics/" + name
+		pods = append(pods, pod)
+	}
+
+	// Not scheduled yet, so skipped rather than counted as failed.
+	pending := getTestServerPod(t, healthy.URL)
+	pending.Name = "pending"
+	pending.Status = corev1.PodStatus{Phase: corev1.PodPending}
+	pods = append(pods, pending)
+
+	// Known to the API server, but not watched by the pod cache.
+	unwatched := getTestServerPod(t, healthy.URL)
+	unwatched.Name = "unwatched"
+
+	objects := []runtime.Object{unwatched}
+
+	for _, pod := range pods {
+		objects = append(objects, pod)
+	}
+
+	mapper := apimeta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion})
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Pod"), apimeta.RESTScopeNamespace)
+
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
+
+	p := New(logger, dynamicfake.NewSimpleDynamicClient(scheme.Scheme, objects...), startPodCache(t, PodCacheConfig{}, pods...), getDefaultRules(t), mapper, Config{
+		CacheExpiration: time.Minute,
+		Concurrency:     2,
+		PodTimeout:      100 * time.Millisecond,
+	})
+
+	info := provider.CustomMetricInfo{
+		GroupResource: schema.GroupResource{Resource: "pods"},
+		Metric:        fpm.MetricListenQueue,
+		Namespaced:    true,
+	}
+
+	before, err := testutil.GetHistogramVecFromGatherer(registry, metricsNamespace+"_selector_failed_pods", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	start := time.Now()
+
+	list, err := p.GetMetricBySelector(context.Background(), "default", labels.Everything(), info, labels.Everything())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("expected the slow pod to time out, took %s", time.Since(start))
+	}
+
+	var names []string
+
+	for _, item := range list.Items {
+		names = append(names, item.DescribedObject.Name)
+
+		if item.Value.MilliValue() != 3000 {
+			t.Fatalf("expected 3, got %s", item.Value.String())
+		}
+	}
+
+	slices.Sort(names)
+
+	if !slices.Equal(names, []string{"healthy-1", "healthy-2"}) {
+		t.Fatalf("expected partial results for the healthy pods, got %v", names)
+	}
+
+	failed, err := testutil.GetHistogramVecFromGatherer(registry, metricsNamespace+"_selector_failed_pods", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if sum := failed.GetAggregatedSampleSum() - before.GetAggregatedSampleSum(); sum != 2 {
+		t.Fatalf("expected 2 failed pods to be recorded, got %v", sum)
+	}
+}
+
+func TestGetMetricNotFinite(t *testing.T) {
+	prom := `
+# TYPE phpfpm_process_utilization gauge
+phpfpm_process_utilization{pool="web"} NaN
+phpfpm_process_utilization{pool="admin"} +Inf
+phpfpm_process_utilization{pool="cron"} -Inf
+phpfpm_process_utilization{pool="worker"} 1.7976931348623157e+308
+phpfpm_process_utilization{pool="queue"} 1.7976931348623157e+308
+`
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(prom))
+	}))
+	defer mockServer.Close()
+
+	for _, pool := range []string{"web", "admin", "cron"} {
+		_, err := getMetric(mockServer.URL, fpm.MetricProcessUtilization, labels.SelectorFromSet(labels.Set{"pool": pool}), AggregationNone, 0)
+		if err == nil || !strings.Contains(err.Error(), "not a finite number") {
+			t.Fatalf("expected a not finite error for pool %s: %v", pool, err)
+		}
+	}
+
+	// Very large values are finite.
+	if _, err := getMetric(mockServer.URL, fpm.MetricProcessUtilization, labels.SelectorFromSet(labels.Set{"pool": "worker"}), AggregationNone, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	selector, err := labels.Parse("pool in (worker,queue)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The sum overflows.
+	_, err = getMetric(mockServer.URL, fpm.MetricProcessUtilization, selector, AggregationSum, 0)
+	if err == nil || !strings.Contains(err.Error(), "not a finite number") {
+		t.Fatalf("expected a not finite error when the aggregation overflows: %v", err)
+	}
+}
+
+func TestNewQuantity(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{value: 0.7, expected: "700m"},
+		{value: 0.0004, expected: "0"},
+		{value: 0.0005, expected: "1m"},
+		{value: 1.2345, expected: "1235m"},
+		{value: -2.5, expected: "-2500m"},
+		{value: 101, expected: "101"},
+		{value: 9e15, expected: "9P"},
+		{value: 1.5e20, expected: "150e18"},
+	}
+
+	for _, tc := range tests {
+		quantity, err := newQuantity(tc.value)
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", tc.value, err)
+		}
+
+		if quantity.String() != tc.expected {
+			t.Fatalf("expected %s for %v, got %s", tc.expected, tc.value, quantity.String())
+		}
+	}
+
+	// Very large values keep the precision of a float64.
+	for _, value := range []float64{9.3e15, 123456789012345678901.0, -4.2e30, 4.2e114} {
+		quantity, err := newQuantity(value)
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", value, err)
+		}
+
+		if diff := math.Abs(quantity.AsApproximateFloat64()-value) / math.Abs(value); diff > 1e-14 {
+			t.Fatalf("expected %v, got %s", value, quantity.String())
+		}
+	}
+
+	for _, value := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), math.MaxFloat64} {
+		if _, err := newQuantity(value); err == nil {
+			t.Fatalf("expected an error for %v", value)
+		}
+	}
+}
+
+func TestGetMetricByNameFractional(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("# TYPE phpfpm_process_utilization gauge\nphpfpm_process_utilization 0.7\n"))
+	}))
+	defer mockServer.Close()
+
+	pod := getTestServerPod(t, mockServer.URL)
+
+	p := getObjectProvider(t, getDefaultRules(t), []*corev1.Pod{pod}, pod)
+
+	info := provider.CustomMetricInfo{
+		GroupResource: PodsResource,
+		Metric:        fpm.MetricProcessUtilization,
+		Namespaced:    true,
+	}
+
+	value, err := p.GetMetricByName(context.Background(), types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}, info, labels.Everything())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if value.Value.String() != "700m" {
+		t.Fatalf("expected 700m, got %s", value.Value.String())
+	}
+}
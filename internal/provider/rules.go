@@ -0,0 +1,328 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider"
+	"sigs.k8s.io/yaml"
+)
+
+// RulesFile declares which scraped series are exposed as metrics eg.
+//
+//	rules:
+//	  - name: drupal_listen_queue
+//	    series: phpfpm_listen_queue
+//	    selector: pool=drupal
+//	    resources: [pods, deployments.apps]
+//	    aggregation: max
+//	  - name: request_duration_p99
+//	    series: php_request_duration_seconds
+//	    quantile: 0.99
+type RulesFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule for exposing a scraped series as a metric.
+type Rule struct {
+	// Name of the exposed metric.
+	Name string `json:"name"`
+	// Series which is scraped from the pod. Defaults to the name.
+	Series string `json:"series,omitempty"`
+	// Selector which the series labels must match eg. pool=web
+	Selector string `json:"selector,omitempty"`
+	// Resources which the metric is associated with eg. deployments.apps
+	// Defaults to pods and every supported object resource.
+	Resources []string `json:"resources,omitempty"`
+	// Aggregation used when multiple series match within a pod, and across the pods which back an object eg. a Deployment.
+	// Pod and object aggregation annotations take precedence. Objects default to sum across their pods.
+	Aggregation Aggregation `json:"aggregation,omitempty"`
+	// Quantile returned for histograms and summaries eg. 0.99
+	Quantile float64 `json:"quantile,omitempty"`
+}
+
+// Compiled rule which is used for serving a metric.
+type metricRule struct {
+	name        string
+	series      string
+	selector    labels.Selector
+	resources   []schema.GroupResource
+	aggregation Aggregation
+	quantile    float64
+}
+
+// Helper function to check if a metric is associated with a resource.
+func (r metricRule) supports(resource schema.GroupResource) bool {
+	return slices.Contains(r.resources, resource)
+}
+
+// Helper function to combine the rule selector with the selector from the request.
+func (r metricRule) selectorFor(metricSelector labels.Selector) labels.Selector {
+	selector := r.selector
+	if selector == nil {
+		selector = labels.Everything()
+	}
+
+	if metricSelector == nil {
+		return selector
+	}
+
+	requirements, _ := metricSelector.Requirements()
+
+	return selector.Add(requirements...)
+}
+
+// RuleSet which has been loaded from a rules file.
+type RuleSet struct {
+	rules []metricRule
+}
+
+// DefaultRuleSet which exposes every metric provided by the sidecar, for pods and every supported object resource.
+func DefaultRuleSet() *RuleSet {
+	var rules []Rule
+
+	for _, metric := range Metrics {
+		rules = append(rules, Rule{
+			Name: metric,
+		})
+	}
+
+	set, err := NewRuleSet(RulesFile{Rules: rules})
+	if err != nil {
+		panic(err)
+	}
+
+	return set
+}
+
+// NewRuleSet validates and compiles the rules.
+func NewRuleSet(file RulesFile) (*RuleSet, error) {
+	set := &RuleSet{}
+
+	var errs []error
+
+	for i, rule := range file.Rules {
+		compiled, err := compileRule(rule)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %d: %w", i, err))
+			continue
+		}
+
+		if _, found := set.get(compiled.name); found {
+			errs = append(errs, fmt.Errorf("rule %d: duplicate metric name: %q", i, compiled.name))
+			continue
+		}
+
+		set.rules = append(set.rules, compiled)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return set, nil
+}
+
+// ParseRuleSet from YAML.
+func ParseRuleSet(data []byte) (*RuleSet, error) {
+	var file RulesFile
+
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %w", err)
+	}
+
+	// Most likely a file which is still being written, rather than an intent to expose nothing.
+	if len(file.Rules) == 0 {
+		return nil, errors.New("rules file does not declare any rules")
+	}
+
+	return NewRuleSet(file)
+}
+
+// Helper function to validate and compile a rule.
+func compileRule(rule Rule) (metricRule, error) {
+	if rule.Name == "" {
+		return metricRule{}, errors.New("name is required")
+	}
+
+	if rule.Quantile < 0 || rule.Quantile > 1 {
+		return metricRule{}, fmt.Errorf("quantile must be between 0 and 1: %v", rule.Quantile)
+	}
+
+	compiled := metricRule{
+		name:     rule.Name,
+		series:   rule.Series,
+		quantile: rule.Quantile,
+	}
+
+	if compiled.series == "" {
+		compiled.series = rule.Name
+	}
+
+	selector, err := labels.Parse(rule.Selector)
+	if err != nil {
+		return metricRule{}, fmt.Errorf("invalid selector: %w", err)
+	}
+
+	compiled.selector = selector
+
+	compiled.aggregation, err = ParseAggregation(string(rule.Aggregation))
+	if err != nil {
+		return metricRule{}, err
+	}
+
+	supported := append([]schema.GroupResource{PodsResource}, ObjectResources...)
+
+	if len(rule.Resources) == 0 {
+		compiled.resources = supported
+	}
+
+	for _, value := range rule.Resources {
+		resource := schema.ParseGroupResource(value)
+
+		if !slices.Contains(supported, resource) {
+			return metricRule{}, fmt.Errorf("unsupported resource: %q", value)
+		}
+
+		compiled.resources = append(compiled.resources, resource)
+	}
+
+	return compiled, nil
+}
+
+// Helper function to get a rule by metric name.
+func (s *RuleSet) get(name string) (metricRule, bool) {
+	for _, rule := range s.rules {
+		if rule.name == name {
+			return rule, true
+		}
+	}
+
+	return metricRule{}, false
+}
+
+// List the metrics which are exposed by the rules.
+func (s *RuleSet) List() []provider.CustomMetricInfo {
+	return listMetrics(s.rules)
+}
+
+// Helper function to list the metrics for each resource which a rule is associated with.
+func listMetrics(rules []metricRule) []provider.CustomMetricInfo {
+	var metrics []provider.CustomMetricInfo
+
+	for _, rule := range rules {
+		for _, resource := range rule.resources {
+			metrics = append(metrics, provider.CustomMetricInfo{
+				GroupResource: resource,
+				Metric:        rule.name,
+				Namespaced:    true,
+			})
+		}
+	}
+
+	return metrics
+}
+
+// RulesConfig for loading the rules file.
+type RulesConfig struct {
+	// Path to the rules file. The default rules are used when empty.
+	Path string
+	// ReloadInterval for checking the rules file for changes. Disabled when zero.
+	ReloadInterval time.Duration
+}
+
+// Rules which are loaded from a file and reloaded when it changes.
+type Rules struct {
+	logger  *slog.Logger
+	config  RulesConfig
+	current atomic.Pointer[RuleSet]
+	// Contents of the rules file which were last loaded, used to detect changes.
+	data []byte
+}
+
+// NewRules loads the rules file, or the default rules when a path is not configured.
+func NewRules(logger *slog.Logger, config RulesConfig) (*Rules, error) {
+	r := &Rules{
+		logger: logger,
+		config: config,
+	}
+
+	if config.Path == "" {
+		r.current.Store(DefaultRuleSet())
+		return r, nil
+	}
+
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Get the current rules.
+func (r *Rules) Get() *RuleSet {
+	return r.current.Load()
+}
+
+// Start checking the rules file for changes until the context is cancelled.
+// Invalid changes are logged and the previous rules are kept.
+func (r *Rules) Start(ctx context.Context) {
+	if r.config.Path == "" || r.config.ReloadInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(r.config.ReloadInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				changed, err := r.reload()
+				if err != nil {
+					rulesReloads.WithLabelValues("error").Inc()
+					r.logger.Error("failed to reload rules", "path", r.config.Path, "error", err.Error())
+					continue
+				}
+
+				if changed {
+					rulesReloads.WithLabelValues("success").Inc()
+					r.logger.Info("reloaded rules", "path", r.config.Path)
+				}
+			}
+		}
+	}()
+}
+
+// Helper function to load the rules file if it has changed.
+func (r *Rules) reload() (bool, error) {
+	data, err := os.ReadFile(r.config.Path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read rules: %w", err)
+	}
+
+	if r.data != nil && bytes.Equal(data, r.data) {
+		return false, nil
+	}
+
+	set, err := ParseRuleSet(data)
+	if err != nil {
+		return false, err
+	}
+
+	r.data = data
+	r.current.Store(set)
+
+	return true, nil
+}
@@ -0,0 +1,302 @@
+package provider
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider"
+
+	"github.com/skpr/fpm-metrics-adapter/internal/fpm"
+)
+
+// Helper function to load the default rules.
+func getDefaultRules(t *testing.T) *Rules {
+	rules, err := NewRules(slog.New(slog.DiscardHandler), RulesConfig{})
+	if err != nil {
+		t.Fatalf("failed to load rules: %v", err)
+	}
+
+	return rules
+}
+
+// Helper function to build a rule which exposes a series as is.
+func testRule(metric string) metricRule {
+	return metricRule{
+		name:   metric,
+		series: metric,
+	}
+}
+
+// Helper function to write a rules file for the test.
+// The file is replaced atomically, like a mounted ConfigMap, so a partially written file is never loaded.
+func writeRules(t *testing.T, path, data string) {
+	tmp := path + ".tmp"
+
+	if err := os.WriteFile(tmp, []byte(data), 0o600); err != nil {
+		t.Fatalf("failed to write rules: %v", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("failed to write rules: %v", err)
+	}
+}
+
+func TestParseRuleSet(t *testing.T) {
+	set, err := ParseRuleSet([]byte(`
+rules:
+  - name: drupal_listen_queue
+    series: phpfpm_listen_queue
+    selector: pool=drupal
+    resources: [pods, deployments.apps]
+    aggregation: max
+    quantile: 0.99
+  - name: phpfpm_active_processes
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rule, found := set.get("drupal_listen_queue")
+	if !found {
+		t.Fatal("expected the renamed metric to be found")
+	}
+
+	if rule.series != fpm.MetricListenQueue {
+		t.Fatalf("expected series %q, got %q", fpm.MetricListenQueue, rule.series)
+	}
+
+	if rule.aggregation != AggregationMax {
+		t.Fatalf("expected aggregation %q, got %q", AggregationMax, rule.aggregation)
+	}
+
+	if rule.quantile != 0.99 {
+		t.Fatalf("expected quantile 0.99, got %v", rule.quantile)
+	}
+
+	if !rule.supports(schema.GroupResource{Group: "apps", Resource: "deployments"}) || rule.supports(schema.GroupResource{Resource: "services"}) {
+		t.Fatalf("unexpected resources: %v", rule.resources)
+	}
+
+	selector := rule.selectorFor(labels.SelectorFromSet(labels.Set{"instance": "a"}))
+
+	if selector.String() != "instance=a,pool=drupal" {
+		t.Fatalf("expected the selectors to be combined, got %q", selector)
+	}
+
+	rule, found = set.get(fpm.MetricActiveProcesses)
+	if !found {
+		t.Fatal("expected the metric to be found")
+	}
+
+	if rule.series != fpm.MetricActiveProcesses {
+		t.Fatalf("expected the series to default to the name, got %q", rule.series)
+	}
+
+	// Exposed for pods and every object resource.
+	if len(set.List()) != 2+1+len(ObjectResources) {
+		t.Fatalf("unexpected metrics: %v", set.List())
+	}
+}
+
+func TestParseRuleSetInvalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"missing name":          "rules: [{series: phpfpm_listen_queue}]",
+		"duplicate name":        "rules: [{name: a}, {name: a}]",
+		"invalid selector":      "rules: [{name: a, selector: 'pool in (web'}]",
+		"invalid aggregation":   "rules: [{name: a, aggregation: median}]",
+		"unsupported resource":  "rules: [{name: a, resources: [nodes]}]",
+		"invalid quantile":      "rules: [{name: a, quantile: 95}]",
+		"unknown field":         "rules: [{name: a, rename: b}]",
+		"invalid yaml document": "rules: {",
+		"no rules":              "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseRuleSet([]byte(data)); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestDefaultRuleSet(t *testing.T) {
+	metrics := getDefaultRules(t).Get().List()
+
+	if len(metrics) != len(Metrics)*(1+len(ObjectResources)) {
+		t.Fatalf("expected every metric for every resource, got %d", len(metrics))
+	}
+
+	if !slices.ContainsFunc(metrics, func(info provider.CustomMetricInfo) bool {
+		return info.Metric == fpm.MetricListenQueue && info.GroupResource == PodsResource
+	}) {
+		t.Fatalf("expected %s to be exposed for pods", fpm.MetricListenQueue)
+	}
+}
+
+func TestRulesReload(t *testing.T) {
+	registerTestMetrics(t)
+
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+
+	writeRules(t, path, "rules: [{name: a}]")
+
+	rules, err := NewRules(slog.New(slog.DiscardHandler), RulesConfig{
+		Path:           path,
+		ReloadInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rules.Start(ctx)
+
+	writeRules(t, path, "rules: [{name: b}]")
+
+	waitForRule(t, rules, "b")
+
+	// Invalid changes keep the previous rules.
+	writeRules(t, path, "rules: [{name: c, aggregation: median}]")
+
+	time.Sleep(50 * time.Millisecond)
+
+	if _, found := rules.Get().get("b"); !found {
+		t.Fatal("expected the previous rules to be kept")
+	}
+
+	writeRules(t, path, "rules: [{name: d}]")
+
+	waitForRule(t, rules, "d")
+}
+
+// Helper function to wait for a rule to be loaded.
+func waitForRule(t *testing.T, rules *Rules, name string) {
+	deadline := time.Now().Add(time.Second)
+
+	for time.Now().Before(deadline) {
+		if _, found := rules.Get().get(name); found {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("expected rule %q to be loaded", name)
+}
+
+func TestNewRulesInvalid(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+
+	if _, err := NewRules(logger, RulesConfig{Path: filepath.Join(t.TempDir(), "missing.yaml")}); err == nil {
+		t.Fatal("expected an error for a missing rules file")
+	}
+
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+
+	writeRules(t, path, "rules: [{name: a, resources: [nodes]}]")
+
+	if _, err := NewRules(logger, RulesConfig{Path: path}); err == nil {
+		t.Fatal("expected an error for invalid rules")
+	}
+}
+
+func TestGetMetricByNameRules(t *testing.T) {
+	registerTestMetrics(t)
+
+	prom := `
+# TYPE phpfpm_listen_queue gauge
+phpfpm_listen_queue{pool="drupal"} 3
+phpfpm_listen_queue{pool="drupal-cron"} 4
+phpfpm_listen_queue{pool="wordpress"} 5
+`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(prom))
+	}))
+	defer server.Close()
+
+	pod := getTestServerPod(t, server.URL)
+
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+
+	writeRules(t, path, `
+rules:
+  - name: drupal_listen_queue
+    series: phpfpm_listen_queue
+    selector: pool in (drupal, drupal-cron)
+    resources: [pods]
+    aggregation: sum
+`)
+
+	logger := slog.New(slog.DiscardHandler)
+
+	rules, err := NewRules(logger, RulesConfig{Path: path})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mapper := apimeta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion})
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Pod"), apimeta.RESTScopeNamespace)
+
+	p := New(logger, dynamicfake.NewSimpleDynamicClient(scheme.Scheme, pod), startPodCache(t, PodCacheConfig{}, pod), rules, mapper, Config{
+		CacheExpiration: time.Minute,
+	})
+
+	name := types.NamespacedName{Namespace: "default", Name: pod.Name}
+
+	info := provider.CustomMetricInfo{
+		GroupResource: PodsResource,
+		Metric:        "drupal_listen_queue",
+		Namespaced:    true,
+	}
+
+	value, err := p.GetMetricByName(context.Background(), name, info, labels.Everything())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if value.Value.MilliValue() != 7000 {
+		t.Fatalf("expected 7, got %s", value.Value.String())
+	}
+
+	if value.Metric.Name != "drupal_listen_queue" {
+		t.Fatalf("expected the renamed metric, got %q", value.Metric.Name)
+	}
+
+	// The request selector further filters the series.
+	value, err = p.GetMetricByName(context.Background(), name, info, labels.SelectorFromSet(labels.Set{"pool": "drupal-cron"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if value.Value.MilliValue() != 4000 {
+		t.Fatalf("expected 4, got %s", value.Value.String())
+	}
+
+	for _, info := range []provider.CustomMetricInfo{
+		// Not declared by the rules.
+		{GroupResource: PodsResource, Metric: fpm.MetricListenQueue, Namespaced: true},
+		// Not associated with the resource.
+		{GroupResource: schema.GroupResource{Group: "apps", Resource: "deployments"}, Metric: "drupal_listen_queue", Namespaced: true},
+	} {
+		if _, err := p.GetMetricByName(context.Background(), name, info, labels.Everything()); !apierrors.IsNotFound(err) {
+			t.Fatalf("expected not found error for %s %s, got %v", info.GroupResource, info.Metric, err)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Metric families scraped from an endpoint at a point in time.
+type scrapeResult struct {
+	families  map[string]*dto.MetricFamily
+	timestamp time.Time
+	// Previous scrape of the endpoint, used for computing rates. Nil for the first scrape.
+	previous *scrapeResult
+}
+
+// Helper function to get the labels of a series.
+func seriesLabels(series *dto.Metric) labels.Set {
+	set := labels.Set{}
+
+	for _, label := range series.GetLabel() {
+		set[label.GetName()] = label.GetValue()
+	}
+
+	return set
+}
+
+// Helper function to find the series in the previous scrape which has the same labels.
+func (r *scrapeResult) previousSeries(metric string, series *dto.Metric) (*dto.Metric, time.Duration, error) {
+	if r.previous == nil {
+		return nil, 0, errors.New("a previous scrape is required for computing a rate")
+	}
+
+	elapsed := r.timestamp.Sub(r.previous.timestamp)
+	if elapsed <= 0 {
+		return nil, 0, errors.New("a previous scrape is required for computing a rate")
+	}
+
+	set := seriesLabels(series)
+
+	for _, previous := range r.previous.families[metric].GetMetric() {
+		if labels.Equals(set, seriesLabels(previous)) {
+			return previous, elapsed, nil
+		}
+	}
+
+	return nil, 0, fmt.Errorf("series %s was not found in the previous scrape", set.String())
+}
+
+// Helper function to get the value of a series.
+// Counters are converted to a per-second rate and summaries return the quantile.
+func (r *scrapeResult) seriesValue(metric string, series *dto.Metric, quantile float64) (float64, error) {
+	switch {
+	case series.GetGauge() != nil:
+		return series.GetGauge().GetValue(), nil
+	case series.GetUntyped() != nil:
+		return series.GetUntyped().GetValue(), nil
+	case series.GetCounter() != nil:
+		return r.counterRate(metric, series)
+	case series.GetSummary() != nil:
+		return summaryQuantile(series.GetSummary(), quantile)
+	}
+
+	return 0, errors.New("unsupported metric type")
+}
+
+// Helper function to compute the per-second rate of a counter since the previous scrape.
+func (r *scrapeResult) counterRate(metric string, series *dto.Metric) (float64, error) {
+	previous, elapsed, err := r.previousSeries(metric, series)
+	if err != nil {
+		return 0, err
+	}
+
+	current := series.GetCounter().GetValue()
+
+	increase := current - previous.GetCounter().GetValue()
+
+	// The counter was reset eg. FPM was restarted.
+	if increase < 0 {
+		increase = current
+	}
+
+	return increase / elapsed.Seconds(), nil
+}
+
+// Helper function to get a quantile which has been computed by the exporter.
+func summaryQuantile(summary *dto.Summary, quantile float64) (float64, error) {
+	for _, q := range summary.GetQuantile() {
+		if math.Abs(q.GetQuantile()-quantile) < 1e-9 {
+			return q.GetValue(), nil
+		}
+	}
+
+	return 0, fmt.Errorf("summary does not have the %v quantile", quantile)
+}
+
+// Cumulative count of observations less than or equal to the upper bound.
+type bucket struct {
+	upperBound float64
+	count      float64
+}
+
+// Helper function to compute a quantile of the observations since the previous scrape, across all matching series.
+// Buckets are summed across the series and the quantile is interpolated the same way as histogram_quantile in Prometheus.
+func (r *scrapeResult) histogramQuantile(metric string, matched []*dto.Metric, quantile float64) (float64, error) {
+	counts := map[float64]float64{}
+
+	for _, series := range matched {
+		previous, _, err := r.previousSeries(metric, series)
+		if err != nil {
+			return 0, err
+		}
+
+		current := histogramBuckets(series.GetHistogram())
+		before := histogramBuckets(previous.GetHistogram())
+
+		// The histogram was reset, so every observation happened since the previous scrape.
+		reset := series.GetHistogram().GetSampleCount() < previous.GetHistogram().GetSampleCount()
+
+		for upperBound, count := range current {
+			if !reset {
+				count -= before[upperBound]
+			}
+
+			counts[upperBound] += count
+		}
+	}
+
+	var buckets []bucket
+
+	for upperBound, count := range counts {
+		buckets = append(buckets, bucket{upperBound: upperBound, count: count})
+	}
+
+	slices.SortFunc(buckets, func(a, b bucket) int {
+		switch {
+		case a.upperBound < b.upperBound:
+			return -1
+		case a.upperBound > b.upperBound:
+			return 1
+		}
+
+		return 0
+	})
+
+	return bucketQuantile(quantile, buckets)
+}
+
+// Helper function to get the cumulative buckets of a histogram, including the +Inf bucket.
+func histogramBuckets(histogram *dto.Histogram) map[float64]float64 {
+	buckets := map[float64]float64{
+		math.Inf(1): float64(histogram.GetSampleCount()),
+	}
+
+	for _, b := range histogram.GetBucket() {
+		buckets[b.GetUpperBound()] = float64(b.GetCumulativeCount())
+	}
+
+	return buckets
+}
+
+// Helper function to interpolate a quantile from sorted cumulative buckets.
+func bucketQuantile(quantile float64, buckets []bucket) (float64, error) {
+	if len(buckets) < 2 {
+		return 0, errors.New("histogram requires at least one bucket in addition to +Inf")
+	}
+
+	total := buckets[len(buckets)-1].count
+	if total <= 0 {
+		return 0, errors.New("histogram does not have any observations since the previous scrape")
+	}
+
+	rank := quantile * total
+
+	i, _ := slices.BinarySearchFunc(buckets, rank, func(b bucket, rank float64) int {
+		switch {
+		case b.count < rank:
+			return -1
+		case b.count > rank:
+			return 1
+		}
+
+		return 0
+	})
+
+	// Observations above the highest finite bucket are reported as that bound.
+	if i >= len(buckets)-1 {
+		return buckets[len(buckets)-2].upperBound, nil
+	}
+
+	var lowerBound, lowerCount float64
+
+	if i > 0 {
+		lowerBound = buckets[i-1].upperBound
+		lowerCount = buckets[i-1].count
+	} else if buckets[0].upperBound <= 0 {
+		// The first bucket only has an upper bound when it is not positive.
+		return buckets[0].upperBound, nil
+	}
+
+	upper := buckets[i]
+
+	if upper.count == lowerCount {
+		return upper.upperBound, nil
+	}
+
+	return lowerBound + (upper.upperBound-lowerBound)*(rank-lowerCount)/(upper.count-lowerCount), nil
+}
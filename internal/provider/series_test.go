@@ -0,0 +1,242 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Helper function to parse metric families from the text format.
+func parseFamilies(t *testing.T, text string) map[string]*dto.MetricFamily {
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+
+	families, err := parser.TextToMetricFamilies(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("failed to parse metrics: %v", err)
+	}
+
+	return families
+}
+
+// Helper function to build a scrape which follows a previous scrape.
+func getScrapeResult(t *testing.T, previous, current string, elapsed time.Duration) *scrapeResult {
+	now := time.Now()
+
+	return &scrapeResult{
+		families:  parseFamilies(t, current),
+		timestamp: now,
+		previous: &scrapeResult{
+			families:  parseFamilies(t, previous),
+			timestamp: now.Add(-elapsed),
+		},
+	}
+}
+
+func TestSelectMetricCounter(t *testing.T) {
+	result := getScrapeResult(t, `
+# TYPE php_requests_total counter
+php_requests_total{pool="web"} 100
+php_requests_total{pool="admin"} 50
+`, `
+# TYPE php_requests_total counter
+php_requests_total{pool="web"} 130
+php_requests_total{pool="admin"} 20
+php_requests_total{pool="cron"} 5
+`, 10*time.Second)
+
+	tests := []struct {
+		selector    string
+		aggregation Aggregation
+		expected    float64
+		err         string
+	}{
+		{selector: "pool=web", expected: 3},
+		// The counter was reset, so the current value is the increase.
+		{selector: "pool=admin", expected: 2},
+		{selector: "pool in (web,admin)", aggregation: AggregationSum, expected: 5},
+		{selector: "pool=cron", err: "was not found in the previous scrape"},
+	}
+
+	for _, tc := range tests {
+		selector, err := labels.Parse(tc.selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		value, err := selectMetric(result, "php_requests_total", selector, tc.aggregation, DefaultQuantile, 0)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing %q for selector %q, got: %v", tc.err, tc.selector, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("unexpected error for selector %q: %v", tc.selector, err)
+		}
+
+		if value != tc.expected {
+			t.Fatalf("expected %v for selector %q, got %v", tc.expected, tc.selector, value)
+		}
+	}
+}
+
+func TestSelectMetricHistogram(t *testing.T) {
+	histogram := `
+# TYPE php_request_duration_seconds histogram
+php_request_duration_seconds_bucket{route="a",le="0.1"} %d
+php_request_duration_seconds_bucket{route="a",le="0.5"} %d
+php_request_duration_seconds_bucket{route="a",le="1"} %d
+php_request_duration_seconds_bucket{route="a",le="+Inf"} %d
+php_request_duration_seconds_sum{route="a"} 0
+php_request_duration_seconds_count{route="a"} %d
+php_request_duration_seconds_bucket{route="b",le="0.1"} %d
+php_request_duration_seconds_bucket{route="b",le="0.5"} %d
+php_request_duration_seconds_bucket{route="b",le="1"} %d
+php_request_duration_seconds_bucket{route="b",le="+Inf"} %d
+php_request_duration_seconds_sum{route="b"} 0
+php_request_duration_seconds_count{route="b"} %d
+`
+
+	// Since the previous scrape, each route observed 20 requests up to 0.5s and 20 requests up to 1s.
+	result := getScrapeResult(t,
+		fmt.Sprintf(histogram, 5, 10, 15, 15, 15, 5, 10, 15, 15, 15),
+		fmt.Sprintf(histogram, 5, 30, 55, 55, 55, 5, 30, 55, 55, 55),
+		time.Minute,
+	)
+
+	for quantile, expected := range map[float64]float64{
+		0.5:  0.5,
+		0.95: 0.95,
+		1:    1,
+	} {
+		value, err := selectMetric(result, "php_request_duration_seconds", labels.Everything(), AggregationNone, quantile, 0)
+		if err != nil {
+			t.Fatalf("unexpected error for quantile %v: %v", quantile, err)
+		}
+
+		if math.Abs(value-expected) > 1e-9 {
+			t.Fatalf("expected %v for quantile %v, got %v", expected, quantile, value)
+		}
+	}
+
+	// Nothing was observed between the scrapes.
+	result = getScrapeResult(t,
+		fmt.Sprintf(histogram, 5, 10, 15, 15, 15, 5, 10, 15, 15, 15),
+		fmt.Sprintf(histogram, 5, 10, 15, 15, 15, 5, 10, 15, 15, 15),
+		time.Minute,
+	)
+
+	if _, err := selectMetric(result, "php_request_duration_seconds", labels.Everything(), AggregationNone, 0.5, 0); err == nil {
+		t.Fatal("expected an error when there are no observations")
+	}
+
+	// Observations above the highest bucket are reported as the highest bound.
+	result = getScrapeResult(t,
+		fmt.Sprintf(histogram, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0),
+		fmt.Sprintf(histogram, 0, 0, 0, 10, 10, 0, 0, 0, 0, 0),
+		time.Minute,
+	)
+
+	value, err := selectMetric(result, "php_request_duration_seconds", labels.Everything(), AggregationNone, 0.5, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if value != 1 {
+		t.Fatalf("expected the highest bound, got %v", value)
+	}
+
+	// A previous scrape is required.
+	result.previous = nil
+
+	if _, err := selectMetric(result, "php_request_duration_seconds", labels.Everything(), AggregationNone, 0.5, 0); err == nil {
+		t.Fatal("expected an error without a previous scrape")
+	}
+}
+
+func TestSelectMetricSummary(t *testing.T) {
+	result := &scrapeResult{
+		families: parseFamilies(t, `
+# TYPE php_request_duration_seconds summary
+php_request_duration_seconds{pool="web",quantile="0.5"} 0.2
+php_request_duration_seconds{pool="web",quantile="0.95"} 0.8
+php_request_duration_seconds_sum{pool="web"} 100
+php_request_duration_seconds_count{pool="web"} 200
+php_request_duration_seconds{pool="admin",quantile="0.5"} 0.1
+php_request_duration_seconds{pool="admin",quantile="0.95"} 1.2
+php_request_duration_seconds_sum{pool="admin"} 10
+php_request_duration_seconds_count{pool="admin"} 20
+`),
+		timestamp: time.Now(),
+	}
+
+	value, err := selectMetric(result, "php_request_duration_seconds", labels.SelectorFromSet(labels.Set{"pool": "web"}), AggregationNone, 0.95, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if value != 0.8 {
+		t.Fatalf("expected 0.8, got %v", value)
+	}
+
+	value, err = selectMetric(result, "php_request_duration_seconds", labels.Everything(), AggregationMax, 0.95, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if value != 1.2 {
+		t.Fatalf("expected 1.2, got %v", value)
+	}
+
+	if _, err := selectMetric(result, "php_request_duration_seconds", labels.Everything(), AggregationMax, 0.99, 0); err == nil {
+		t.Fatal("expected an error for a quantile which the summary does not have")
+	}
+}
+
+func TestScrapeCounterRate(t *testing.T) {
+	var requests atomic.Int64
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "# TYPE php_requests_total counter\nphp_requests_total %d\n", requests.Add(100))
+	}))
+	defer mockServer.Close()
+
+	pod := getTestServerPod(t, mockServer.URL)
+
+	p := &Provider{
+		cache:   cache.New(10*time.Millisecond, time.Minute),
+		history: cache.New(time.Minute, time.Minute),
+	}
+
+	rule := testRule("php_requests_total")
+
+	if _, err := p.scrape(context.Background(), pod, rule, labels.Everything()); err == nil {
+		t.Fatal("expected an error for the first scrape")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	value, err := p.scrape(context.Background(), pod, rule, labels.Everything())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The counter increased by 100 in at least 20ms.
+	if value <= 0 || value > 100/0.02 {
+		t.Fatalf("expected a positive rate of at most 5000/s, got %v", value)
+	}
+}
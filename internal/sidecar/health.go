@@ -0,0 +1,95 @@
+package sidecar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/skpr/fpm-metrics-adapter/internal/fpm"
+)
+
+const (
+	// LivenessPath which responds with the liveness of FPM.
+	LivenessPath = "/healthz"
+	// ReadinessPath which responds with the readiness of FPM.
+	ReadinessPath = "/readyz"
+)
+
+// LivenessHandler responds with a 200 when every pool responds to a ping with the expected response.
+func (s *Server) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.live(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+}
+
+// ReadinessHandler responds with a 200 when every pool is live and not saturated.
+func (s *Server) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.live(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		if err := s.ready(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+}
+
+// Helper function to ping every pool.
+func (s *Server) live(ctx context.Context) error {
+	return s.eachClient(ctx, func(ctx context.Context, client fpm.FcmClient) error {
+		return client.Ping(ctx)
+	})
+}
+
+// Helper function to check that no pool has reached the queue threshold.
+func (s *Server) ready(ctx context.Context) error {
+	if s.config.QueueThreshold <= 0 {
+		return nil
+	}
+
+	return s.eachClient(ctx, func(ctx context.Context, client fpm.FcmClient) error {
+		status, err := client.QueryStatus(ctx)
+		if err != nil {
+			return err
+		}
+
+		if status.ListenQueue >= s.config.QueueThreshold {
+			return fmt.Errorf("saturated with %d items in the listen queue", status.ListenQueue)
+		}
+
+		return nil
+	})
+}
+
+// Helper function to call the given function for every client concurrently.
+// Errors are labelled with the endpoint and joined.
+func (s *Server) eachClient(ctx context.Context, fn func(context.Context, fpm.FcmClient) error) error {
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(s.clients))
+	)
+
+	for i, client := range s.clients {
+		wg.Go(func() {
+			if err := fn(ctx, client); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", s.endpoints[i], err)
+			}
+		})
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
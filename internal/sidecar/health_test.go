@@ -0,0 +1,84 @@
+package sidecar
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skpr/fpm-metrics-adapter/internal/fpm"
+)
+
+// TestLiveness tests that the liveness endpoint reflects whether every
+// pool responds to a ping.
+func TestLiveness(t *testing.T) {
+	web := &FpmCountClient{pool: "web"}
+	cron := &FpmCountClient{pool: "cron"}
+
+	config := ServerConfig{
+		Endpoints: []string{"127.0.0.1:9000", "127.0.0.1:9001"},
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
+	server, err := NewServer(logger, config, []fpm.FcmClient{web, cron})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, serveRequest(server.LivenessHandler()))
+
+	cron.throw = true
+
+	req := httptest.NewRequest(http.MethodGet, LivenessPath, nil)
+	rec := httptest.NewRecorder()
+
+	server.LivenessHandler().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "127.0.0.1:9001: error")
+	assert.NotContains(t, rec.Body.String(), "127.0.0.1:9000")
+
+	// Liveness does not query the status page.
+	assert.Equal(t, 0, web.count)
+}
+
+// TestReadiness tests that the readiness endpoint responds as unavailable
+// once the listen queue reaches the threshold.
+func TestReadiness(t *testing.T) {
+	client := &FpmCountClient{}
+
+	config := ServerConfig{
+		QueueThreshold: 3,
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
+	server, err := NewServer(logger, config, []fpm.FcmClient{client})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The listen queue increases with each query.
+	assert.Equal(t, http.StatusOK, serveRequest(server.ReadinessHandler()))
+	assert.Equal(t, http.StatusOK, serveRequest(server.ReadinessHandler()))
+	assert.Equal(t, http.StatusServiceUnavailable, serveRequest(server.ReadinessHandler()))
+	assert.Equal(t, 3, client.count)
+
+	// Pools which are not live are not ready.
+	client.throw = true
+	assert.Equal(t, http.StatusServiceUnavailable, serveRequest(server.ReadinessHandler()))
+}
+
+// TestReadinessThresholdDisabled tests that the status page is not queried
+// when the queue threshold is disabled.
+func TestReadinessThresholdDisabled(t *testing.T) {
+	client := &FpmCountClient{}
+
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
+	server, err := NewServer(logger, ServerConfig{}, []fpm.FcmClient{client})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, serveRequest(server.ReadinessHandler()))
+	assert.Equal(t, 0, client.count)
+}
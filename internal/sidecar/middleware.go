@@ -1,33 +1,236 @@
 package sidecar
 
 import (
+	"context"
 	"net/http"
+	"slices"
+	"sync"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/skpr/fpm-metrics-adapter/internal/fpm"
 )
 
+// Metrics which are served regardless of the staleness of the pool.
+var unstaleMetrics = []string{
+	fpm.MetricUp,
+	fpm.MetricLastSuccessfulScrape,
+	fpm.MetricScrapeErrors,
+	fpm.MetricConfigInfo,
+	fpm.MetricConfigMaxChildren,
+	fpm.MetricConfigStartServers,
+	fpm.MetricConfigMinSpareServers,
+	fpm.MetricConfigMaxSpareServers,
+	fpm.MetricConfigMaxRequests,
+}
+
 // Handler wraps promhttp.Handler to fetch data.
+// Only used when the background poller is disabled.
 func (s *Server) RefreshMetricsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Flood control for requests to fpm.
-		if time.Now().After(s.metrics.LastUpdate.Add(1 * time.Second)) {
-			s.logger.Debug("collecting FPM status")
+		s.refresh(r.Context())
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// StalenessGatherer drops the status metrics of pools which have not been queried successfully within the max age.
+// Health and configuration metrics are always served, so a stale pool still reports that it is down.
+func (s *Server) StalenessGatherer(next prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := next.Gather()
+
+		stale := s.stalePools()
+		if len(stale) == 0 {
+			return families, err
+		}
+
+		for _, family := range families {
+			if slices.Contains(unstaleMetrics, family.GetName()) {
+				continue
+			}
+
+			family.Metric = slices.DeleteFunc(family.Metric, func(metric *dto.Metric) bool {
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == LabelPool {
+						_, ok := stale[label.GetValue()]
+						return ok
+					}
+				}
+
+				return false
+			})
+		}
+
+		families = slices.DeleteFunc(families, func(family *dto.MetricFamily) bool {
+			return len(family.Metric) == 0
+		})
+
+		return families, err
+	})
+}
+
+// Helper function to refresh the metrics with the latest FPM status.
+func (s *Server) refresh(ctx context.Context) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Flood control for requests to fpm.
+	if !time.Now().After(s.metrics.LastUpdate.Add(s.config.FloodControl)) {
+		return
+	}
+
+	s.logger.Debug("collecting FPM status")
+
+	s.metrics.LastUpdate = time.Now()
+
+	for _, status := range s.queryStatuses(ctx) {
+		s.setPoolMetrics(status)
+	}
+}
+
+// Helper function to get the names of the pools which have not been queried successfully within the max age.
+func (s *Server) stalePools() map[string]struct{} {
+	if s.config.MaxAge <= 0 {
+		return nil
+	}
+
+	stale := make(map[string]struct{})
+
+	for i := range s.lastSuccess {
+		if time.Since(time.Unix(0, s.lastSuccess[i].Load())) > s.config.MaxAge {
+			stale[*s.names[i].Load()] = struct{}{}
+		}
+	}
+
+	return stale
+}
 
-			s.metrics.LastUpdate = time.Now()
+// Helper function to query the status of all pools concurrently.
+// Pools which fail to respond are logged, counted and omitted from the result.
+func (s *Server) queryStatuses(ctx context.Context) []fpm.Status {
+	var (
+		wg       sync.WaitGroup
+		statuses = make([]*fpm.Status, len(s.clients))
+	)
 
-			status, err := s.client.QueryStatus()
+	for i, client := range s.clients {
+		wg.Go(func() {
+			status, err := client.QueryStatus(ctx)
 			if err != nil {
-				s.logger.Error("failed to collect FPM status", "error", err.Error())
+				s.logger.Error("failed to collect FPM status", "pool", *s.names[i].Load(), "error", err.Error())
 				return
 			}
 
-			s.metrics.ListenQueue.Set(float64(status.ListenQueue))
-			s.metrics.ListenQueueLen.Set(float64(status.ListenQueueLen))
-			s.metrics.IdleProcesses.Set(float64(status.IdleProcesses))
-			s.metrics.ActiveProcesses.Set(float64(status.ActiveProcesses))
-			s.metrics.TotalProcesses.Set(float64(status.TotalProcesses))
-			s.metrics.MaxActiveProcesses.Set(float64(status.MaxActiveProcesses))
+			statuses[i] = &status
+		})
+	}
+
+	wg.Wait()
+
+	var (
+		now    = time.Now()
+		result []fpm.Status
+	)
+
+	for i, status := range statuses {
+		if status == nil {
+			s.setPoolDown(i)
+			continue
 		}
 
-		next.ServeHTTP(w, r)
-	})
+		// Some formats eg. openmetrics do not report the pool name.
+		if status.Pool == "" {
+			status.Pool = s.endpoints[i]
+		}
+
+		status.Pool = sanitizeLabelValue(status.Pool)
+
+		// Metrics are labelled by pool, so a second endpoint reporting the same pool would overwrite the first.
+		if owner, ok := s.poolOwner(i, status.Pool); ok {
+			s.logger.Error("pool is already reported by another endpoint", "pool", status.Pool, "endpoint", s.endpoints[i], "owner", s.endpoints[owner])
+			s.setPoolDown(i)
+			continue
+		}
+
+		s.setPoolName(i, status.Pool)
+		s.lastSuccess[i].Store(now.UnixNano())
+
+		s.metrics.Up.WithLabelValues(status.Pool).Set(1)
+		s.metrics.LastSuccessfulScrape.WithLabelValues(status.Pool).Set(float64(now.Unix()))
+
+		result = append(result, *status)
+	}
+
+	return result
+}
+
+// Helper function to record a failed query of a pool.
+func (s *Server) setPoolDown(i int) {
+	name := *s.names[i].Load()
+
+	s.metrics.Up.WithLabelValues(name).Set(0)
+	s.metrics.ScrapeErrors.WithLabelValues(name).Inc()
+}
+
+// Helper function to find another client which already reports a pool.
+func (s *Server) poolOwner(i int, pool string) (int, bool) {
+	for j := range s.names {
+		if j != i && *s.names[j].Load() == pool {
+			return j, true
+		}
+	}
+
+	return 0, false
+}
+
+// Helper function to record the name of a pool once it has responded.
+// Until then the pool is identified by its endpoint, so its health metrics are moved to the new name.
+func (s *Server) setPoolName(i int, pool string) {
+	previous := *s.names[i].Load()
+	if previous == pool {
+		return
+	}
+
+	var scrapeErrors dto.Metric
+
+	if err := s.metrics.ScrapeErrors.WithLabelValues(previous).Write(&scrapeErrors); err == nil && scrapeErrors.GetCounter().GetValue() > 0 {
+		s.metrics.ScrapeErrors.WithLabelValues(pool).Add(scrapeErrors.GetCounter().GetValue())
+	}
+
+	s.metrics.Up.DeleteLabelValues(previous)
+	s.metrics.ScrapeErrors.DeleteLabelValues(previous)
+	s.metrics.LastSuccessfulScrape.DeleteLabelValues(previous)
+
+	s.names[i].Store(&pool)
+}
+
+// Helper function to set the pool-level metrics.
+func (s *Server) setPoolMetrics(status fpm.Status) {
+	s.metrics.ListenQueue.WithLabelValues(status.Pool).Set(float64(status.ListenQueue))
+	s.metrics.ListenQueueLen.WithLabelValues(status.Pool).Set(float64(status.ListenQueueLen))
+	s.metrics.IdleProcesses.WithLabelValues(status.Pool).Set(float64(status.IdleProcesses))
+	s.metrics.ActiveProcesses.WithLabelValues(status.Pool).Set(float64(status.ActiveProcesses))
+	s.metrics.TotalProcesses.WithLabelValues(status.Pool).Set(float64(status.TotalProcesses))
+	s.metrics.MaxActiveProcesses.WithLabelValues(status.Pool).Set(float64(status.MaxActiveProcesses))
+	s.metrics.StartTime.WithLabelValues(status.Pool).Set(float64(status.StartTime))
+	s.metrics.StartSince.WithLabelValues(status.Pool).Set(float64(status.StartSince))
+	s.metrics.MaxListenQueue.WithLabelValues(status.Pool).Set(float64(status.MaxListenQueue))
+	// Only exported when pm.max_children is known, otherwise dynamic and ondemand pools would always appear saturated.
+	if utilization, ok := s.pools[status.Pool].ProcessUtilization(status); ok {
+		s.metrics.ProcessUtilization.WithLabelValues(status.Pool).Set(utilization)
+	} else {
+		s.metrics.ProcessUtilization.DeleteLabelValues(status.Pool)
+	}
+	s.metrics.ListenQueueUtilization.WithLabelValues(status.Pool).Set(status.ListenQueueUtilization())
+
+	now := time.Now()
+
+	s.metrics.ListenQueueWindow.Observe(status.Pool, now, float64(status.ListenQueue))
+	s.metrics.ActiveProcessesWindow.Observe(status.Pool, now, float64(status.ActiveProcesses))
+
+	s.metrics.Totals.Set(status)
+	s.metrics.Processes.Set(status)
 }
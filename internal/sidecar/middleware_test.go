@@ -1,14 +1,17 @@
 package sidecar
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 
@@ -16,20 +19,41 @@ import (
 )
 
 type FpmCountClient struct {
-	count int
-	throw bool
+	pool      string
+	count     int
+	throw     bool
+	processes []fpm.Process
 }
 
-func (client *FpmCountClient) QueryStatus() (fpm.Status, error) {
+func (client *FpmCountClient) QueryStatus(_ context.Context) (fpm.Status, error) {
 	client.count++
 	if client.throw {
 		return fpm.Status{}, fmt.Errorf("error")
 	}
+	pool := client.pool
+	if pool == "" {
+		pool = "www"
+	}
 	return fpm.Status{
+		Pool:            pool,
+		ProcessManager:  "dynamic",
+		ListenQueue:     int64(client.count),
+		ListenQueueLen:  4,
 		ActiveProcesses: int64(5 * client.count),
+		TotalProcesses:  10,
+		AcceptedConn:    int64(100 * client.count),
+		SlowRequests:    int64(client.count),
+		Processes:       client.processes,
 	}, nil
 }
 
+func (client *FpmCountClient) Ping(_ context.Context) error {
+	if client.throw {
+		return fmt.Errorf("error")
+	}
+	return nil
+}
+
 // TestMetricsRefreshFloodControl tests that the metrics middleware will not
 // refresh metrics more than once per second.
 func TestMetricsRefreshFloodControl(t *testing.T) {
@@ -37,28 +61,57 @@ func TestMetricsRefreshFloodControl(t *testing.T) {
 
 	config := ServerConfig{}
 	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
-	server, err := NewServer(logger, config, client)
+	server, err := NewServer(logger, config, []fpm.FcmClient{client})
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	triggerMetricsMiddleware(server)
 	assert.Equal(t, 1, client.count)
-	assert.Equal(t, float64(5), testutil.ToFloat64(server.metrics.ActiveProcesses))
+	assert.Equal(t, float64(5), testutil.ToFloat64(server.metrics.ActiveProcesses.WithLabelValues("www")))
 	// Within a second, client call should be skipped.
 	triggerMetricsMiddleware(server)
 	assert.Equal(t, 1, client.count)
-	assert.Equal(t, float64(5), testutil.ToFloat64(server.metrics.ActiveProcesses))
+	assert.Equal(t, float64(5), testutil.ToFloat64(server.metrics.ActiveProcesses.WithLabelValues("www")))
 
 	server.metrics.LastUpdate = time.Now().Add(-5 * time.Second)
 
 	triggerMetricsMiddleware(server)
 	assert.Equal(t, 2, client.count)
-	assert.Equal(t, float64(10), testutil.ToFloat64(server.metrics.ActiveProcesses))
+	assert.Equal(t, float64(10), testutil.ToFloat64(server.metrics.ActiveProcesses.WithLabelValues("www")))
 	// Within a second, client call should be skipped.
 	triggerMetricsMiddleware(server)
 	assert.Equal(t, 2, client.count)
-	assert.Equal(t, float64(10), testutil.ToFloat64(server.metrics.ActiveProcesses))
+	assert.Equal(t, float64(10), testutil.ToFloat64(server.metrics.ActiveProcesses.WithLabelValues("www")))
+}
+
+// TestMetricsRefreshFloodControlConfig tests that the flood control window
+// can be configured.
+func TestMetricsRefreshFloodControlConfig(t *testing.T) {
+	client := &FpmCountClient{}
+
+	config := ServerConfig{
+		FloodControl: time.Minute,
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
+	server, err := NewServer(logger, config, []fpm.FcmClient{client})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	triggerMetricsMiddleware(server)
+	assert.Equal(t, 1, client.count)
+
+	// Outside the default window, but inside the configured window.
+	server.metrics.LastUpdate = time.Now().Add(-5 * time.Second)
+
+	triggerMetricsMiddleware(server)
+	assert.Equal(t, 1, client.count)
+
+	server.metrics.LastUpdate = time.Now().Add(-2 * time.Minute)
+
+	triggerMetricsMiddleware(server)
+	assert.Equal(t, 2, client.count)
 }
 
 // TestMetricsRefreshQueryStatusError tests that the metrics middleware will
@@ -68,14 +121,14 @@ func TestMetricsRefreshQueryStatusError(t *testing.T) {
 
 	config := ServerConfig{}
 	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
-	server, err := NewServer(logger, config, client)
+	server, err := NewServer(logger, config, []fpm.FcmClient{client})
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	triggerMetricsMiddleware(server)
 	assert.Equal(t, 1, client.count)
-	assert.Equal(t, float64(5), testutil.ToFloat64(server.metrics.ActiveProcesses))
+	assert.Equal(t, float64(5), testutil.ToFloat64(server.metrics.ActiveProcesses.WithLabelValues("www")))
 
 	server.metrics.LastUpdate = time.Now().Add(-time.Second)
 	client.throw = true
@@ -83,7 +136,383 @@ func TestMetricsRefreshQueryStatusError(t *testing.T) {
 	triggerMetricsMiddleware(server)
 	assert.Equal(t, 2, client.count)
 	// Value cached in event of query status throwing error.
-	assert.Equal(t, float64(5), testutil.ToFloat64(server.metrics.ActiveProcesses))
+	assert.Equal(t, float64(5), testutil.ToFloat64(server.metrics.ActiveProcesses.WithLabelValues("www")))
+}
+
+// TestMetricsRefreshPool tests that the metrics middleware will export
+// pool information and running totals as counters.
+func TestMetricsRefreshPool(t *testing.T) {
+	client := &FpmCountClient{}
+
+	config := ServerConfig{}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
+	server, err := NewServer(logger, config, []fpm.FcmClient{client})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	triggerMetricsMiddleware(server)
+	assert.NoError(t, testutil.CollectAndCompare(server.metrics.Processes, strings.NewReader(`
+# HELP phpfpm_pool_info The name and process manager type of the fpm pool.
+# TYPE phpfpm_pool_info gauge
+phpfpm_pool_info{pool="www",process_manager="dynamic"} 1
+`), fpm.MetricPoolInfo))
+	// Not exported for a dynamic pool without pm.max_children.
+	assert.Equal(t, 0, testutil.CollectAndCount(server.metrics.ProcessUtilization))
+	assert.Equal(t, 0.25, testutil.ToFloat64(server.metrics.ListenQueueUtilization.WithLabelValues("www")))
+	assert.NoError(t, testutil.CollectAndCompare(server.metrics.Totals, strings.NewReader(`
+# HELP phpfpm_accepted_connections_total The total number of connections accepted by the fpm pool.
+# TYPE phpfpm_accepted_connections_total counter
+phpfpm_accepted_connections_total{pool="www"} 100
+# HELP phpfpm_slow_requests_total The total number of requests which exceeded the fpm request_slowlog_timeout.
+# TYPE phpfpm_slow_requests_total counter
+phpfpm_slow_requests_total{pool="www"} 1
+`), fpm.MetricAcceptedConnections, fpm.MetricSlowRequests))
+
+	server.metrics.LastUpdate = time.Now().Add(-5 * time.Second)
+
+	triggerMetricsMiddleware(server)
+	assert.NoError(t, testutil.CollectAndCompare(server.metrics.Totals, strings.NewReader(`
+# HELP phpfpm_accepted_connections_total The total number of connections accepted by the fpm pool.
+# TYPE phpfpm_accepted_connections_total counter
+phpfpm_accepted_connections_total{pool="www"} 200
+# HELP phpfpm_slow_requests_total The total number of requests which exceeded the fpm request_slowlog_timeout.
+# TYPE phpfpm_slow_requests_total counter
+phpfpm_slow_requests_total{pool="www"} 2
+`), fpm.MetricAcceptedConnections, fpm.MetricSlowRequests))
+}
+
+// TestMetricsRefreshProcesses tests that the metrics middleware will export
+// per-process and aggregated process metrics.
+func TestMetricsRefreshProcesses(t *testing.T) {
+	client := &FpmCountClient{
+		processes: []fpm.Process{
+			{Pid: 10, State: "Idle", Script: "/app/index.php", Requests: 42, LastRequestCPU: 12.5},
+			{Pid: 11, State: "Running", Script: "/app/cron.php", Requests: 7, RequestDuration: 3000},
+			{Pid: 12, State: "Running", Script: "/app/cron.php", Requests: 3},
+		},
+	}
+
+	config := ServerConfig{}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
+	server, err := NewServer(logger, config, []fpm.FcmClient{client})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	triggerMetricsMiddleware(server)
+	assert.NoError(t, testutil.CollectAndCompare(server.metrics.Processes, strings.NewReader(`
+# HELP phpfpm_process_requests The number of requests served by the fpm process.
+# TYPE phpfpm_process_requests gauge
+phpfpm_process_requests{pid="10",pool="www"} 42
+phpfpm_process_requests{pid="11",pool="www"} 7
+phpfpm_process_requests{pid="12",pool="www"} 3
+# HELP phpfpm_process_last_request_cpu The %cpu of the last request served by the fpm process.
+# TYPE phpfpm_process_last_request_cpu gauge
+phpfpm_process_last_request_cpu{pid="10",pool="www"} 12.5
+phpfpm_process_last_request_cpu{pid="11",pool="www"} 0
+phpfpm_process_last_request_cpu{pid="12",pool="www"} 0
+# HELP phpfpm_process_state The current state of the fpm process and the script it is serving.
+# TYPE phpfpm_process_state gauge
+phpfpm_process_state{pid="10",pool="www",script="/app/index.php",state="Idle"} 1
+phpfpm_process_state{pid="11",pool="www",script="/app/cron.php",state="Running"} 1
+phpfpm_process_state{pid="12",pool="www",script="/app/cron.php",state="Running"} 1
+# HELP phpfpm_processes_by_state The number of fpm processes in each state.
+# TYPE phpfpm_processes_by_state gauge
+phpfpm_processes_by_state{pool="www",state="Idle"} 1
+phpfpm_processes_by_state{pool="www",state="Running"} 2
+# HELP phpfpm_script_busy_processes The number of fpm processes currently busy serving each script.
+# TYPE phpfpm_script_busy_processes gauge
+phpfpm_script_busy_processes{pool="www",script="/app/cron.php"} 2
+`), fpm.MetricProcessRequests, fpm.MetricProcessLastRequestCPU, fpm.MetricProcessState, fpm.MetricProcessesByState, fpm.MetricScriptBusyProcesses))
+
+	// Processes which have gone away should no longer be exported.
+	client.processes = client.processes[:1]
+	server.metrics.LastUpdate = time.Now().Add(-5 * time.Second)
+
+	triggerMetricsMiddleware(server)
+	assert.NoError(t, testutil.CollectAndCompare(server.metrics.Processes, strings.NewReader(`
+# HELP phpfpm_process_requests The number of requests served by the fpm process.
+# TYPE phpfpm_process_requests gauge
+phpfpm_process_requests{pid="10",pool="www"} 42
+`), fpm.MetricProcessRequests, fpm.MetricScriptBusyProcesses))
+}
+
+// TestMetricsRefreshProcessesInvalidUTF8 tests that label values reported by FPM
+// which are not valid UTF-8 are sanitized rather than failing the scrape.
+func TestMetricsRefreshProcessesInvalidUTF8(t *testing.T) {
+	client := &FpmCountClient{
+		pool: "www\xff",
+		processes: []fpm.Process{
+			{Pid: 10, State: "Running", Script: "/app/\xffindex.php"},
+			{Pid: 11, State: "Running", Script: "/app/\xfeindex.php"},
+		},
+	}
+
+	config := ServerConfig{}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
+	server, err := NewServer(logger, config, []fpm.FcmClient{client})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NotPanics(t, func() { triggerMetricsMiddleware(server) })
+	assert.NoError(t, testutil.CollectAndCompare(server.metrics.Processes, strings.NewReader(`
+# HELP phpfpm_process_state The current state of the fpm process and the script it is serving.
+# TYPE phpfpm_process_state gauge
+phpfpm_process_state{pid="10",pool="www�",script="/app/�index.php",state="Running"} 1
+phpfpm_process_state{pid="11",pool="www�",script="/app/�index.php",state="Running"} 1
+# HELP phpfpm_script_busy_processes The number of fpm processes currently busy serving each script.
+# TYPE phpfpm_script_busy_processes gauge
+phpfpm_script_busy_processes{pool="www�",script="/app/�index.php"} 2
+`), fpm.MetricProcessState, fpm.MetricScriptBusyProcesses))
+}
+
+// TestMetricsRefreshMultiplePools tests that the metrics middleware will query
+// every pool and label the metrics accordingly.
+func TestMetricsRefreshMultiplePools(t *testing.T) {
+	web := &FpmCountClient{pool: "web"}
+	admin := &FpmCountClient{pool: "admin"}
+	cron := &FpmCountClient{pool: "cron", throw: true}
+
+	config := ServerConfig{}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
+	server, err := NewServer(logger, config, []fpm.FcmClient{web, admin, cron})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin.count = 1
+
+	triggerMetricsMiddleware(server)
+	assert.Equal(t, 1, web.count)
+	assert.Equal(t, 2, admin.count)
+	assert.Equal(t, 1, cron.count)
+	assert.Equal(t, float64(5), testutil.ToFloat64(server.metrics.ActiveProcesses.WithLabelValues("web")))
+	assert.Equal(t, float64(10), testutil.ToFloat64(server.metrics.ActiveProcesses.WithLabelValues("admin")))
+	// Pools which fail to respond are not exported.
+	assert.Equal(t, 2, testutil.CollectAndCount(server.metrics.ActiveProcesses))
+}
+
+// TestMetricsRefreshDuplicatePool tests that a pool reported by more than one
+// endpoint is only exported for the first endpoint.
+func TestMetricsRefreshDuplicatePool(t *testing.T) {
+	web := &FpmCountClient{pool: "web"}
+	duplicate := &FpmCountClient{pool: "web", count: 1}
+
+	config := ServerConfig{
+		Endpoints: []string{"127.0.0.1:9000", "127.0.0.1:9001"},
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
+	server, err := NewServer(logger, config, []fpm.FcmClient{web, duplicate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	triggerMetricsMiddleware(server)
+	assert.Equal(t, float64(5), testutil.ToFloat64(server.metrics.ActiveProcesses.WithLabelValues("web")))
+	assert.Equal(t, 1, testutil.CollectAndCount(server.metrics.ActiveProcesses))
+	assert.Equal(t, float64(1), testutil.ToFloat64(server.metrics.Up.WithLabelValues("web")))
+	// The duplicate is reported as down by its endpoint.
+	assert.Equal(t, float64(0), testutil.ToFloat64(server.metrics.Up.WithLabelValues("127.0.0.1:9001")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(server.metrics.ScrapeErrors.WithLabelValues("127.0.0.1:9001")))
+
+	// The first endpoint keeps the pool when it fails.
+	web.throw = true
+	server.metrics.LastUpdate = time.Now().Add(-5 * time.Second)
+
+	triggerMetricsMiddleware(server)
+	assert.Equal(t, float64(0), testutil.ToFloat64(server.metrics.Up.WithLabelValues("web")))
+	assert.Equal(t, float64(5), testutil.ToFloat64(server.metrics.ActiveProcesses.WithLabelValues("web")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(server.metrics.ScrapeErrors.WithLabelValues("127.0.0.1:9001")))
+}
+
+// TestMetricsRefreshConfig tests that the process manager configuration is
+// exported and used for utilization.
+func TestMetricsRefreshConfig(t *testing.T) {
+	client := &FpmCountClient{}
+
+	config := ServerConfig{
+		ConfigPath:   "../fpm/testdata/config/php-fpm.conf",
+		ConfigPrefix: "../fpm/testdata/config",
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
+	server, err := NewServer(logger, config, []fpm.FcmClient{client})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(server.metrics.ConfigInfo.WithLabelValues("www", "dynamic")))
+	assert.Equal(t, float64(20), testutil.ToFloat64(server.metrics.ConfigMaxChildren.WithLabelValues("www")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(server.metrics.ConfigMaxChildren.WithLabelValues("cron")))
+	assert.Equal(t, float64(1000), testutil.ToFloat64(server.metrics.ConfigMaxRequests.WithLabelValues("www")))
+
+	triggerMetricsMiddleware(server)
+	// 5 active processes out of pm.max_children = 20.
+	assert.Equal(t, 0.25, testutil.ToFloat64(server.metrics.ProcessUtilization.WithLabelValues("www")))
+
+	config.ConfigPath = "../fpm/testdata/config/missing.conf"
+
+	_, err = NewServer(logger, config, []fpm.FcmClient{client})
+	assert.Error(t, err)
+}
+
+// TestMetricsStaleness tests that query health is exported and the status
+// metrics of a pool are no longer served once it exceeds the max age.
+func TestMetricsStaleness(t *testing.T) {
+	client := &FpmCountClient{}
+	api := &FpmCountClient{pool: "api"}
+
+	config := ServerConfig{
+		Endpoints: []string{"127.0.0.1:9000", "127.0.0.1:9001"},
+		MaxAge:    time.Minute,
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
+	server, err := NewServer(logger, config, []fpm.FcmClient{client, api})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := server.newRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gatherer := server.StalenessGatherer(registry)
+
+	client.throw = true
+
+	// Pools are identified by endpoint until they respond.
+	triggerMetricsMiddleware(server)
+	assert.Equal(t, float64(0), testutil.ToFloat64(server.metrics.Up.WithLabelValues("127.0.0.1:9000")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(server.metrics.ScrapeErrors.WithLabelValues("127.0.0.1:9000")))
+
+	client.throw = false
+	server.metrics.LastUpdate = time.Now().Add(-5 * time.Second)
+
+	triggerMetricsMiddleware(server)
+	assert.Equal(t, float64(1), testutil.ToFloat64(server.metrics.Up.WithLabelValues("www")))
+	assert.Equal(t, 2, testutil.CollectAndCount(server.metrics.Up))
+	// Errors recorded against the endpoint are carried over to the pool name.
+	assert.Equal(t, 1, testutil.CollectAndCount(server.metrics.ScrapeErrors))
+	assert.Equal(t, float64(1), testutil.ToFloat64(server.metrics.ScrapeErrors.WithLabelValues("www")))
+	assert.InDelta(t, float64(time.Now().Unix()), testutil.ToFloat64(server.metrics.LastSuccessfulScrape.WithLabelValues("www")), 1)
+
+	client.throw = true
+	server.metrics.LastUpdate = time.Now().Add(-5 * time.Second)
+
+	// Failures within the max age serve the last snapshot.
+	triggerMetricsMiddleware(server)
+	assert.Equal(t, float64(0), testutil.ToFloat64(server.metrics.Up.WithLabelValues("www")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(server.metrics.ScrapeErrors.WithLabelValues("www")))
+	assertGatheredCount(t, gatherer, fpm.MetricActiveProcesses, 2)
+
+	server.lastSuccess[0].Store(time.Now().Add(-2 * time.Minute).UnixNano())
+
+	// Only the status metrics of the stale pool are dropped.
+	assertGatheredCount(t, gatherer, fpm.MetricActiveProcesses, 1)
+	assertGatheredCount(t, gatherer, fpm.MetricPoolInfo, 1)
+	assertGatheredCount(t, gatherer, fpm.MetricUp, 2)
+	assertGatheredCount(t, gatherer, fpm.MetricScrapeErrors, 1)
+	assertGatheredCount(t, gatherer, fpm.MetricLastSuccessfulScrape, 2)
+
+	server.lastSuccess[1].Store(time.Now().Add(-2 * time.Minute).UnixNano())
+
+	assertGatheredCount(t, gatherer, fpm.MetricActiveProcesses, 0)
+	assertGatheredCount(t, gatherer, fpm.MetricUp, 2)
+}
+
+type FpmSlowClient struct {
+	FpmCountClient
+	release chan struct{}
+}
+
+func (client *FpmSlowClient) QueryStatus(ctx context.Context) (fpm.Status, error) {
+	<-client.release
+	return client.FpmCountClient.QueryStatus(ctx)
+}
+
+// TestMetricsStalenessSlowPoll tests that the staleness check does not wait
+// for an in-flight query of the FPM status.
+func TestMetricsStalenessSlowPoll(t *testing.T) {
+	client := &FpmSlowClient{release: make(chan struct{})}
+
+	config := ServerConfig{
+		MaxAge: time.Minute,
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
+	server, err := NewServer(logger, config, []fpm.FcmClient{client})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := server.newRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gatherer := server.StalenessGatherer(registry)
+
+	done := make(chan struct{})
+
+	go func() {
+		server.refresh(context.Background())
+		close(done)
+	}()
+
+	// Wait for the poll to hold the lock.
+	assert.Eventually(t, func() bool {
+		if !server.lock.TryLock() {
+			return true
+		}
+		server.lock.Unlock()
+		return false
+	}, time.Second, time.Millisecond)
+
+	server.lastSuccess[0].Store(time.Now().Add(-2 * time.Minute).UnixNano())
+
+	errs := make(chan error)
+
+	go func() {
+		_, err := gatherer.Gather()
+		errs <- err
+	}()
+
+	select {
+	case err := <-errs:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("staleness check waited for the poll to complete")
+	}
+
+	close(client.release)
+	<-done
+}
+
+// TestNewServerNoClients tests that a server requires at least one client.
+func TestNewServerNoClients(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
+	_, err := NewServer(logger, ServerConfig{}, nil)
+	assert.Error(t, err)
+}
+
+// serveRequest makes a http request to the handler and returns the status code.
+func serveRequest(handler http.Handler) int {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	return rec.Code
+}
+
+// assertGatheredCount asserts the number of series gathered for a metric.
+func assertGatheredCount(t *testing.T, gatherer prometheus.Gatherer, metric string, expected int) {
+	t.Helper()
+
+	count, err := testutil.GatherAndCount(gatherer, metric)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, count, metric)
 }
 
 // triggerMetricsMiddleware makes a http request to trigger middleware.
@@ -0,0 +1,24 @@
+package sidecar
+
+import (
+	"context"
+	"time"
+)
+
+// Poll FPM for the latest status at the configured interval until the context is cancelled.
+func (s *Server) Poll(ctx context.Context) {
+	ticker := time.NewTicker(s.config.PollInterval)
+	defer ticker.Stop()
+
+	s.refresh(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Stopping poller")
+			return
+		case <-ticker.C:
+			s.refresh(ctx)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package sidecar
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skpr/fpm-metrics-adapter/internal/fpm"
+)
+
+// TestPoll tests that the poller refreshes metrics in the background and
+// stops when the context is cancelled.
+func TestPoll(t *testing.T) {
+	client := &FpmCountClient{}
+
+	config := ServerConfig{
+		PollInterval: 10 * time.Millisecond,
+		FloodControl: time.Millisecond,
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
+	server, err := NewServer(logger, config, []fpm.FcmClient{client})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+
+	go func() {
+		server.Poll(ctx)
+		close(done)
+	}()
+
+	// Each poll increments the active processes by 5.
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(server.metrics.ActiveProcesses.WithLabelValues("www")) >= 15
+	}, time.Second, 5*time.Millisecond)
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("poller did not stop after the context was cancelled")
+	}
+
+	// No more queries once the poller has stopped.
+	count := client.count
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, count, client.count)
+}
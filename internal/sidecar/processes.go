@@ -0,0 +1,152 @@
+package sidecar
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/skpr/fpm-metrics-adapter/internal/fpm"
+)
+
+// Processes reported by the latest FPM status of each pool, along with the pool information.
+// Each scrape is built from a single status, so processes which have gone away are never exported.
+type Processes struct {
+	lock  sync.RWMutex
+	pools map[string]fpm.Status
+
+	poolInfo            *prometheus.Desc
+	state               *prometheus.Desc
+	requests            *prometheus.Desc
+	requestDuration     *prometheus.Desc
+	lastRequestCPU      *prometheus.Desc
+	lastRequestMemory   *prometheus.Desc
+	processesByState    *prometheus.Desc
+	scriptBusyProcesses *prometheus.Desc
+}
+
+// NewProcesses for exporting FPM pool information and per-process metrics.
+func NewProcesses() *Processes {
+	return &Processes{
+		pools: make(map[string]fpm.Status),
+		poolInfo: prometheus.NewDesc(
+			fpm.MetricPoolInfo,
+			"The name and process manager type of the fpm pool.",
+			[]string{LabelPool, "process_manager"}, nil,
+		),
+		state: prometheus.NewDesc(
+			fpm.MetricProcessState,
+			"The current state of the fpm process and the script it is serving.",
+			[]string{LabelPool, "pid", "state", "script"}, nil,
+		),
+		requests: prometheus.NewDesc(
+			fpm.MetricProcessRequests,
+			"The number of requests served by the fpm process.",
+			[]string{LabelPool, "pid"}, nil,
+		),
+		requestDuration: prometheus.NewDesc(
+			fpm.MetricProcessRequestDuration,
+			"The duration in microseconds of the last request served by the fpm process.",
+			[]string{LabelPool, "pid"}, nil,
+		),
+		lastRequestCPU: prometheus.NewDesc(
+			fpm.MetricProcessLastRequestCPU,
+			"The %cpu of the last request served by the fpm process.",
+			[]string{LabelPool, "pid"}, nil,
+		),
+		lastRequestMemory: prometheus.NewDesc(
+			fpm.MetricProcessLastRequestMemory,
+			"The memory in bytes consumed by the last request served by the fpm process.",
+			[]string{LabelPool, "pid"}, nil,
+		),
+		processesByState: prometheus.NewDesc(
+			fpm.MetricProcessesByState,
+			"The number of fpm processes in each state.",
+			[]string{LabelPool, "state"}, nil,
+		),
+		scriptBusyProcesses: prometheus.NewDesc(
+			fpm.MetricScriptBusyProcesses,
+			"The number of fpm processes currently busy serving each script.",
+			[]string{LabelPool, "script"}, nil,
+		),
+	}
+}
+
+// Set the latest status for a pool.
+func (p *Processes) Set(status fpm.Status) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.pools[status.Pool] = status
+}
+
+// Describe implements prometheus.Collector.
+func (p *Processes) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.poolInfo
+	ch <- p.state
+	ch <- p.requests
+	ch <- p.requestDuration
+	ch <- p.lastRequestCPU
+	ch <- p.lastRequestMemory
+	ch <- p.processesByState
+	ch <- p.scriptBusyProcesses
+}
+
+// Collect implements prometheus.Collector.
+func (p *Processes) Collect(ch chan<- prometheus.Metric) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	for pool, status := range p.pools {
+		sendConstMetric(ch, p.poolInfo, prometheus.GaugeValue, 1, pool, sanitizeLabelValue(status.ProcessManager))
+
+		var (
+			byState = make(map[string]int)
+			busy    = make(map[string]int)
+		)
+
+		for _, process := range status.Processes {
+			var (
+				pid    = strconv.FormatInt(process.Pid, 10)
+				state  = sanitizeLabelValue(process.State)
+				script = sanitizeLabelValue(process.Script)
+			)
+
+			sendConstMetric(ch, p.state, prometheus.GaugeValue, 1, pool, pid, state, script)
+			sendConstMetric(ch, p.requests, prometheus.GaugeValue, float64(process.Requests), pool, pid)
+			sendConstMetric(ch, p.requestDuration, prometheus.GaugeValue, float64(process.RequestDuration), pool, pid)
+			sendConstMetric(ch, p.lastRequestCPU, prometheus.GaugeValue, process.LastRequestCPU, pool, pid)
+			sendConstMetric(ch, p.lastRequestMemory, prometheus.GaugeValue, float64(process.LastRequestMemory), pool, pid)
+
+			byState[state]++
+
+			if process.Busy() {
+				busy[script]++
+			}
+		}
+
+		for state, count := range byState {
+			sendConstMetric(ch, p.processesByState, prometheus.GaugeValue, float64(count), pool, state)
+		}
+
+		for script, count := range busy {
+			sendConstMetric(ch, p.scriptBusyProcesses, prometheus.GaugeValue, float64(count), pool, script)
+		}
+	}
+}
+
+// Helper function to send a metric, skipping it if it is invalid rather than failing the whole scrape.
+func sendConstMetric(ch chan<- prometheus.Metric, desc *prometheus.Desc, valueType prometheus.ValueType, value float64, labelValues ...string) {
+	metric, err := prometheus.NewConstMetric(desc, valueType, value, labelValues...)
+	if err != nil {
+		return
+	}
+
+	ch <- metric
+}
+
+// Helper function to sanitize a label value reported by FPM, which is not guaranteed to be valid UTF-8 eg. a script path.
+func sanitizeLabelValue(value string) string {
+	return strings.ToValidUTF8(value, "\uFFFD")
+}
@@ -7,6 +7,9 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -15,6 +18,14 @@ import (
 	"github.com/skpr/fpm-metrics-adapter/internal/fpm"
 )
 
+const (
+	// LabelPool is used to identify which FPM pool a metric belongs to.
+	LabelPool = "pool"
+
+	// DefaultFloodControl is the minimum amount of time between FPM status queries.
+	DefaultFloodControl = time.Second
+)
+
 // Server for collecting and returning
 type Server struct {
 	// Used for logging events.
@@ -23,8 +34,20 @@ type Server struct {
 	config ServerConfig
 	// Metrics for the server
 	metrics Metrics
-	// FpmClient for querying the FPM status.
-	client fpm.FcmClient
+	// FpmClients for querying the status of each FPM pool.
+	clients []fpm.FcmClient
+	// Pool configuration loaded from the FPM config, keyed by pool name.
+	pools map[string]fpm.PoolConfig
+	// Endpoints queried by each client, used to identify the client in health checks.
+	endpoints []string
+	// Names of the pools queried by each client, used to label health metrics.
+	// Kept outside of the lock so the staleness check never waits on an in-flight query.
+	names []atomic.Pointer[string]
+	// The last time each client successfully queried its pool, as unix nanoseconds.
+	// Kept outside of the lock so the staleness check never waits on an in-flight query.
+	lastSuccess []atomic.Int64
+	// Ensures only one refresh of the metrics happens at a time.
+	lock sync.Mutex
 }
 
 // ServerConfig which is used by the HTTP server.
@@ -33,74 +56,250 @@ type ServerConfig struct {
 	Port string
 	// Path which will return metrics responses for the metrics adapter.
 	Path string
-	// Endpoint for querying the latest FPM status information.
-	Endpoint string
-	// Timeout for the FPM status query.
-	Timeout time.Duration
+	// Endpoints for querying the latest FPM status information, one per pool.
+	Endpoints []string
+	// ConfigPath to the php-fpm.conf file which declares the pools (optional).
+	ConfigPath string
+	// ConfigPrefix which relative include paths in the FPM config are resolved against.
+	ConfigPrefix string
+	// PollInterval for querying FPM in the background. Metrics are refreshed on request when zero.
+	PollInterval time.Duration
+	// FloodControl is the minimum amount of time between FPM status queries.
+	FloodControl time.Duration
+	// MaxAge of the FPM status before the status metrics of a pool are no longer served. Disabled when zero.
+	MaxAge time.Duration
+	// ReadTimeout for reading an entire request, including the body.
+	ReadTimeout time.Duration
+	// WriteTimeout for writing a response.
+	WriteTimeout time.Duration
+	// IdleTimeout for keep-alive connections waiting for the next request.
+	IdleTimeout time.Duration
+	// DrainTimeout for in-flight requests to complete once shutdown has started.
+	DrainTimeout time.Duration
+	// QueueThreshold is the number of items in the listen queue at which a pool is no longer ready. Disabled when zero.
+	QueueThreshold int64
 }
 
 type Metrics struct {
 	// The last time the FPM status was updated.
 	LastUpdate time.Time
-	// Prometheus metrics.
-	ListenQueue        prometheus.Gauge
-	ListenQueueLen     prometheus.Gauge
-	IdleProcesses      prometheus.Gauge
-	ActiveProcesses    prometheus.Gauge
-	TotalProcesses     prometheus.Gauge
-	MaxActiveProcesses prometheus.Gauge
+	// Health of the FPM status queries, labelled by pool.
+	Up                   *prometheus.GaugeVec
+	LastSuccessfulScrape *prometheus.GaugeVec
+	ScrapeErrors         *prometheus.CounterVec
+	// Prometheus metrics, labelled by pool.
+	ListenQueue        *prometheus.GaugeVec
+	ListenQueueLen     *prometheus.GaugeVec
+	IdleProcesses      *prometheus.GaugeVec
+	ActiveProcesses    *prometheus.GaugeVec
+	TotalProcesses     *prometheus.GaugeVec
+	MaxActiveProcesses *prometheus.GaugeVec
+	StartTime          *prometheus.GaugeVec
+	StartSince         *prometheus.GaugeVec
+	MaxListenQueue     *prometheus.GaugeVec
+	// Derived metrics, labelled by pool.
+	ProcessUtilization     *prometheus.GaugeVec
+	ListenQueueUtilization *prometheus.GaugeVec
+	// Rolling-window statistics, labelled by pool and window.
+	ListenQueueWindow     *RollingWindow
+	ActiveProcessesWindow *RollingWindow
+	// Process manager configuration, labelled by pool.
+	ConfigInfo            *prometheus.GaugeVec
+	ConfigMaxChildren     *prometheus.GaugeVec
+	ConfigStartServers    *prometheus.GaugeVec
+	ConfigMinSpareServers *prometheus.GaugeVec
+	ConfigMaxSpareServers *prometheus.GaugeVec
+	ConfigMaxRequests     *prometheus.GaugeVec
+	// Counters which are reported by FPM as running totals.
+	Totals *Totals
+	// Pool information and per-process metrics, built from the latest status of each pool.
+	Processes *Processes
 }
 
 // NewServer for collecting and responding with the latest FPM status.
-func NewServer(logger *slog.Logger, config ServerConfig, client fpm.FcmClient) (*Server, error) {
+func NewServer(logger *slog.Logger, config ServerConfig, clients []fpm.FcmClient) (*Server, error) {
+	if len(clients) == 0 {
+		return nil, errors.New("at least one fpm client is required")
+	}
+
+	if config.FloodControl == 0 {
+		config.FloodControl = DefaultFloodControl
+	}
+
 	server := &Server{
 		logger: logger,
 		config: config,
 		metrics: Metrics{
-			ListenQueue: prometheus.NewGauge(prometheus.GaugeOpts{
+			Up: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: fpm.MetricUp,
+				Help: "Whether the last query of the fpm status was successful.",
+			}, []string{LabelPool}),
+			LastSuccessfulScrape: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: fpm.MetricLastSuccessfulScrape,
+				Help: "The unix timestamp of the last successful query of the fpm status.",
+			}, []string{LabelPool}),
+			ScrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: fpm.MetricScrapeErrors,
+				Help: "The total number of failed queries of the fpm status.",
+			}, []string{LabelPool}),
+			ListenQueue: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 				Name: fpm.MetricListenQueue,
 				Help: "The number of items in the listen queue.",
-			}),
-			ListenQueueLen: prometheus.NewGauge(prometheus.GaugeOpts{
+			}, []string{LabelPool}),
+			ListenQueueLen: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 				Name: fpm.MetricListenQueueLen,
 				Help: "The total size of the listen queue.",
-			}),
-			IdleProcesses: prometheus.NewGauge(prometheus.GaugeOpts{
+			}, []string{LabelPool}),
+			IdleProcesses: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 				Name: fpm.MetricIdleProcesses,
 				Help: "The number of idle fpm processes.",
-			}),
-			ActiveProcesses: prometheus.NewGauge(prometheus.GaugeOpts{
+			}, []string{LabelPool}),
+			ActiveProcesses: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 				Name: fpm.MetricActiveProcesses,
 				Help: "The number of active fpm processes.",
-			}),
-			TotalProcesses: prometheus.NewGauge(prometheus.GaugeOpts{
+			}, []string{LabelPool}),
+			TotalProcesses: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 				Name: fpm.MetricTotalProcesses,
 				Help: "The total number of processes available in fpm.",
-			}),
-			MaxActiveProcesses: prometheus.NewGauge(prometheus.GaugeOpts{
+			}, []string{LabelPool}),
+			MaxActiveProcesses: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 				Name: fpm.MetricMaxActiveProcesses,
 				Help: "The maximum number of active processes since the FPM master process was started.",
-			}),
+			}, []string{LabelPool}),
+			StartTime: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: fpm.MetricStartTime,
+				Help: "The unix timestamp that the fpm pool was last started.",
+			}, []string{LabelPool}),
+			StartSince: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: fpm.MetricStartSince,
+				Help: "The number of seconds since the fpm pool was last started.",
+			}, []string{LabelPool}),
+			MaxListenQueue: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: fpm.MetricMaxListenQueue,
+				Help: "The maximum number of items in the listen queue since the fpm pool was started.",
+			}, []string{LabelPool}),
+			ProcessUtilization: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: fpm.MetricProcessUtilization,
+				Help: "The ratio of active fpm processes to pm.max_children.",
+			}, []string{LabelPool}),
+			ListenQueueUtilization: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: fpm.MetricListenQueueUtilization,
+				Help: "The ratio of items in the listen queue to the total size of the listen queue.",
+			}, []string{LabelPool}),
+			ListenQueueWindow: NewRollingWindow(
+				fpm.MetricListenQueueAvg,
+				fpm.MetricListenQueueP95,
+				fpm.MetricListenQueueMax,
+				"number of items in the listen queue over the window.",
+			),
+			ActiveProcessesWindow: NewRollingWindow(
+				fpm.MetricActiveProcessesAvg,
+				fpm.MetricActiveProcessesP95,
+				fpm.MetricActiveProcessesMax,
+				"number of active fpm processes over the window.",
+			),
+			ConfigInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: fpm.MetricConfigInfo,
+				Help: "The configured process manager type (pm) of the fpm pool.",
+			}, []string{LabelPool, "pm"}),
+			ConfigMaxChildren: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: fpm.MetricConfigMaxChildren,
+				Help: "The configured maximum number of fpm child processes (pm.max_children).",
+			}, []string{LabelPool}),
+			ConfigStartServers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: fpm.MetricConfigStartServers,
+				Help: "The configured number of fpm child processes created on startup (pm.start_servers).",
+			}, []string{LabelPool}),
+			ConfigMinSpareServers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: fpm.MetricConfigMinSpareServers,
+				Help: "The configured minimum number of idle fpm processes (pm.min_spare_servers).",
+			}, []string{LabelPool}),
+			ConfigMaxSpareServers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: fpm.MetricConfigMaxSpareServers,
+				Help: "The configured maximum number of idle fpm processes (pm.max_spare_servers).",
+			}, []string{LabelPool}),
+			ConfigMaxRequests: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: fpm.MetricConfigMaxRequests,
+				Help: "The configured number of requests each fpm process executes before respawning (pm.max_requests).",
+			}, []string{LabelPool}),
+			Totals:    NewTotals(),
+			Processes: NewProcesses(),
 		},
-		client: client,
+		clients:     clients,
+		pools:       make(map[string]fpm.PoolConfig),
+		endpoints:   make([]string, len(clients)),
+		names:       make([]atomic.Pointer[string], len(clients)),
+		lastSuccess: make([]atomic.Int64, len(clients)),
+	}
+
+	for i := range clients {
+		if i < len(config.Endpoints) {
+			server.endpoints[i] = config.Endpoints[i]
+		} else {
+			server.endpoints[i] = strconv.Itoa(i)
+		}
+
+		server.names[i].Store(&server.endpoints[i])
+
+		// Give each pool the max age to respond after startup.
+		server.lastSuccess[i].Store(time.Now().UnixNano())
+	}
+
+	if config.ConfigPath != "" {
+		fpmConfig, err := fpm.ParseConfig(config.ConfigPath, config.ConfigPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse fpm config: %w", err)
+		}
+
+		server.setConfigMetrics(fpmConfig)
 	}
 
 	return server, nil
 }
 
-// Run the HTTP server.
-func (s *Server) Run(ctx context.Context) error {
-	s.logger.Info("Registering metrics")
+// Helper function to set the process manager configuration metrics.
+func (s *Server) setConfigMetrics(config fpm.Config) {
+	for name, pool := range config.Pools {
+		s.pools[name] = pool
+
+		s.metrics.ConfigInfo.WithLabelValues(name, pool.ProcessManager).Set(1)
+		s.metrics.ConfigMaxChildren.WithLabelValues(name).Set(float64(pool.MaxChildren))
+		s.metrics.ConfigStartServers.WithLabelValues(name).Set(float64(pool.StartServers))
+		s.metrics.ConfigMinSpareServers.WithLabelValues(name).Set(float64(pool.MinSpareServers))
+		s.metrics.ConfigMaxSpareServers.WithLabelValues(name).Set(float64(pool.MaxSpareServers))
+		s.metrics.ConfigMaxRequests.WithLabelValues(name).Set(float64(pool.MaxRequests))
+	}
+}
 
+// Helper function to register the metrics with a new registry.
+func (s *Server) newRegistry() (*prometheus.Registry, error) {
 	var errs []error
 
 	metrics := []prometheus.Collector{
+		s.metrics.Up,
+		s.metrics.LastSuccessfulScrape,
+		s.metrics.ScrapeErrors,
 		s.metrics.ListenQueue,
 		s.metrics.ListenQueueLen,
 		s.metrics.IdleProcesses,
 		s.metrics.ActiveProcesses,
 		s.metrics.TotalProcesses,
 		s.metrics.MaxActiveProcesses,
+		s.metrics.StartTime,
+		s.metrics.StartSince,
+		s.metrics.MaxListenQueue,
+		s.metrics.ProcessUtilization,
+		s.metrics.ListenQueueUtilization,
+		s.metrics.ListenQueueWindow,
+		s.metrics.ActiveProcessesWindow,
+		s.metrics.ConfigInfo,
+		s.metrics.ConfigMaxChildren,
+		s.metrics.ConfigStartServers,
+		s.metrics.ConfigMinSpareServers,
+		s.metrics.ConfigMaxSpareServers,
+		s.metrics.ConfigMaxRequests,
+		s.metrics.Totals,
+		s.metrics.Processes,
 	}
 
 	customRegistry := prometheus.NewRegistry()
@@ -111,16 +310,69 @@ func (s *Server) Run(ctx context.Context) error {
 	}
 
 	if len(errs) > 0 {
-		return fmt.Errorf("failed to register metrics: %w", errors.Join(errs...))
+		return nil, fmt.Errorf("failed to register metrics: %w", errors.Join(errs...))
+	}
+
+	return customRegistry, nil
+}
+
+// Run the HTTP server.
+func (s *Server) Run(ctx context.Context) error {
+	s.logger.Info("Registering metrics")
+
+	customRegistry, err := s.newRegistry()
+	if err != nil {
+		return err
+	}
+
+	handler := promhttp.HandlerFor(s.StalenessGatherer(customRegistry), promhttp.HandlerOpts{})
+
+	if s.config.PollInterval > 0 {
+		s.logger.Info("Starting poller", "interval", s.config.PollInterval.String())
+		go s.Poll(ctx)
+	} else {
+		handler = s.RefreshMetricsMiddleware(handler)
 	}
 
 	mux := http.NewServeMux()
-	mux.Handle(s.config.Path, s.RefreshMetricsMiddleware(promhttp.HandlerFor(
-		customRegistry,
-		promhttp.HandlerOpts{},
-	)))
+	mux.Handle(s.config.Path, handler)
+	mux.Handle(LivenessPath, s.LivenessHandler())
+	mux.Handle(ReadinessPath, s.ReadinessHandler())
 
-	s.logger.Info("Starting server")
+	server := &http.Server{
+		Addr:         s.config.Port,
+		Handler:      mux,
+		ReadTimeout:  s.config.ReadTimeout,
+		WriteTimeout: s.config.WriteTimeout,
+		IdleTimeout:  s.config.IdleTimeout,
+	}
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		s.logger.Info("Starting server")
+		errCh <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.logger.Info("Shutting down server", "drain", s.config.DrainTimeout.String())
+
+	// The parent context is already cancelled, so we need a new one for draining.
+	drainCtx, cancel := context.WithTimeout(context.Background(), s.config.DrainTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(drainCtx); err != nil {
+		return fmt.Errorf("failed to shutdown server: %w", err)
+	}
+
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
 
-	return http.ListenAndServe(s.config.Port, mux)
+	return nil
 }
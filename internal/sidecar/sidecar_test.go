@@ -0,0 +1,64 @@
+package sidecar
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/skpr/fpm-metrics-adapter/internal/fpm"
+)
+
+// TestRunShutdown tests that the server shuts down gracefully when the
+// context is cancelled.
+func TestRunShutdown(t *testing.T) {
+	config := ServerConfig{
+		Port:         "127.0.0.1:0",
+		Path:         "/metrics",
+		PollInterval: time.Second,
+		DrainTimeout: time.Second,
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
+	server, err := NewServer(logger, config, []fpm.FcmClient{&FpmCountClient{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- server.Run(ctx)
+	}()
+
+	// Give the server a moment to start listening.
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+
+	select {
+	case err := <-errCh:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shutdown after the context was cancelled")
+	}
+}
+
+// TestRunListenError tests that listen errors are returned.
+func TestRunListenError(t *testing.T) {
+	config := ServerConfig{
+		Port: "invalid:address:0",
+		Path: "/metrics",
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{}))
+	server, err := NewServer(logger, config, []fpm.FcmClient{&FpmCountClient{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Error(t, server.Run(context.Background()))
+}
@@ -0,0 +1,68 @@
+package sidecar
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/skpr/fpm-metrics-adapter/internal/fpm"
+)
+
+// Totals reported by FPM since each pool was started, exported as counters.
+type Totals struct {
+	lock  sync.RWMutex
+	pools map[string]fpm.Status
+
+	acceptedConnections *prometheus.Desc
+	maxChildrenReached  *prometheus.Desc
+	slowRequests        *prometheus.Desc
+}
+
+// NewTotals for exporting FPM running totals as counters.
+func NewTotals() *Totals {
+	return &Totals{
+		pools: make(map[string]fpm.Status),
+		acceptedConnections: prometheus.NewDesc(
+			fpm.MetricAcceptedConnections,
+			"The total number of connections accepted by the fpm pool.",
+			[]string{LabelPool}, nil,
+		),
+		maxChildrenReached: prometheus.NewDesc(
+			fpm.MetricMaxChildrenReached,
+			"The number of times the fpm pool has reached pm.max_children.",
+			[]string{LabelPool}, nil,
+		),
+		slowRequests: prometheus.NewDesc(
+			fpm.MetricSlowRequests,
+			"The total number of requests which exceeded the fpm request_slowlog_timeout.",
+			[]string{LabelPool}, nil,
+		),
+	}
+}
+
+// Set the latest totals for a pool.
+func (t *Totals) Set(status fpm.Status) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.pools[status.Pool] = status
+}
+
+// Describe implements prometheus.Collector.
+func (t *Totals) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.acceptedConnections
+	ch <- t.maxChildrenReached
+	ch <- t.slowRequests
+}
+
+// Collect implements prometheus.Collector.
+func (t *Totals) Collect(ch chan<- prometheus.Metric) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	for pool, status := range t.pools {
+		sendConstMetric(ch, t.acceptedConnections, prometheus.CounterValue, float64(status.AcceptedConn), pool)
+		sendConstMetric(ch, t.maxChildrenReached, prometheus.CounterValue, float64(status.MaxChildrenReached), pool)
+		sendConstMetric(ch, t.slowRequests, prometheus.CounterValue, float64(status.SlowRequests), pool)
+	}
+}
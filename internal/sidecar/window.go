@@ -0,0 +1,137 @@
+package sidecar
+
+import (
+	"math"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LabelWindow is used to identify the window which a statistic was calculated over.
+const LabelWindow = "window"
+
+// Window which statistics are calculated over.
+type Window struct {
+	// Name used as the label value eg. 1m
+	Name string
+	// Duration of the window.
+	Duration time.Duration
+}
+
+// Windows which rolling statistics are exported for.
+var Windows = []Window{
+	{Name: "30s", Duration: 30 * time.Second},
+	{Name: "1m", Duration: time.Minute},
+	{Name: "5m", Duration: 5 * time.Minute},
+}
+
+// Sample recorded at a point in time.
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// Stats calculated over a window of samples.
+type Stats struct {
+	Avg float64
+	P95 float64
+	Max float64
+}
+
+// RollingWindow records samples for each pool and exports statistics over each of the Windows.
+type RollingWindow struct {
+	// Samples for each pool, oldest first.
+	samples map[string][]Sample
+	// Prometheus metrics, labelled by pool and window.
+	avg *prometheus.GaugeVec
+	p95 *prometheus.GaugeVec
+	max *prometheus.GaugeVec
+}
+
+// NewRollingWindow for exporting the average, 95th percentile and maximum of a metric.
+func NewRollingWindow(avgName, p95Name, maxName, help string) *RollingWindow {
+	return &RollingWindow{
+		samples: make(map[string][]Sample),
+		avg: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: avgName,
+			Help: "The average " + help,
+		}, []string{LabelPool, LabelWindow}),
+		p95: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: p95Name,
+			Help: "The 95th percentile " + help,
+		}, []string{LabelPool, LabelWindow}),
+		max: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: maxName,
+			Help: "The maximum " + help,
+		}, []string{LabelPool, LabelWindow}),
+	}
+}
+
+// Observe a value for a pool and update the statistics.
+func (w *RollingWindow) Observe(pool string, now time.Time, value float64) {
+	samples := append(w.samples[pool], Sample{Time: now, Value: value})
+
+	// Discard samples which have fallen outside of the largest window.
+	retention := Windows[len(Windows)-1].Duration
+
+	for len(samples) > 0 && now.Sub(samples[0].Time) > retention {
+		samples = samples[1:]
+	}
+
+	w.samples[pool] = samples
+
+	for _, window := range Windows {
+		stats := calculateStats(samples, now, window.Duration)
+
+		w.avg.WithLabelValues(pool, window.Name).Set(stats.Avg)
+		w.p95.WithLabelValues(pool, window.Name).Set(stats.P95)
+		w.max.WithLabelValues(pool, window.Name).Set(stats.Max)
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (w *RollingWindow) Describe(ch chan<- *prometheus.Desc) {
+	w.avg.Describe(ch)
+	w.p95.Describe(ch)
+	w.max.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (w *RollingWindow) Collect(ch chan<- prometheus.Metric) {
+	w.avg.Collect(ch)
+	w.p95.Collect(ch)
+	w.max.Collect(ch)
+}
+
+// Helper function to calculate statistics for the samples within a window.
+func calculateStats(samples []Sample, now time.Time, window time.Duration) Stats {
+	var values []float64
+
+	for _, sample := range samples {
+		if now.Sub(sample.Time) <= window {
+			values = append(values, sample.Value)
+		}
+	}
+
+	if len(values) == 0 {
+		return Stats{}
+	}
+
+	slices.Sort(values)
+
+	var sum float64
+
+	for _, value := range values {
+		sum += value
+	}
+
+	// Nearest-rank percentile.
+	rank := int(math.Ceil(0.95*float64(len(values)))) - 1
+
+	return Stats{
+		Avg: sum / float64(len(values)),
+		P95: values[rank],
+		Max: values[len(values)-1],
+	}
+}
@@ -0,0 +1,54 @@
+package sidecar
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRollingWindow tests that statistics are calculated over each window
+// and samples outside the largest window are discarded.
+func TestRollingWindow(t *testing.T) {
+	window := NewRollingWindow("test_avg", "test_p95", "test_max", "test value.")
+
+	now := time.Now()
+
+	// One sample every 10 seconds for 10 minutes, ending with the largest values.
+	for i := 0; i <= 60; i++ {
+		window.Observe("www", now.Add(time.Duration(i-60)*10*time.Second), float64(i))
+	}
+
+	// 30s window: 57, 58, 59, 60
+	assert.Equal(t, 58.5, testutil.ToFloat64(window.avg.WithLabelValues("www", "30s")))
+	assert.Equal(t, float64(60), testutil.ToFloat64(window.p95.WithLabelValues("www", "30s")))
+	assert.Equal(t, float64(60), testutil.ToFloat64(window.max.WithLabelValues("www", "30s")))
+
+	// 1m window: 54 to 60
+	assert.Equal(t, float64(57), testutil.ToFloat64(window.avg.WithLabelValues("www", "1m")))
+
+	// 5m window: 30 to 60
+	assert.Equal(t, float64(45), testutil.ToFloat64(window.avg.WithLabelValues("www", "5m")))
+	assert.Equal(t, float64(59), testutil.ToFloat64(window.p95.WithLabelValues("www", "5m")))
+	assert.Equal(t, 31, len(window.samples["www"]))
+
+	// Pools are tracked separately.
+	window.Observe("admin", now, 3)
+	assert.Equal(t, float64(3), testutil.ToFloat64(window.max.WithLabelValues("admin", "5m")))
+	assert.Equal(t, float64(60), testutil.ToFloat64(window.max.WithLabelValues("www", "5m")))
+}
+
+func TestCalculateStats(t *testing.T) {
+	now := time.Now()
+
+	assert.Equal(t, Stats{}, calculateStats(nil, now, time.Minute))
+
+	var samples []Sample
+
+	for i := 1; i <= 100; i++ {
+		samples = append(samples, Sample{Time: now, Value: float64(101 - i)})
+	}
+
+	assert.Equal(t, Stats{Avg: 50.5, P95: 95, Max: 100}, calculateStats(samples, now, time.Minute))
+}